@@ -3,12 +3,15 @@
 package restapi
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-openapi/errors"
 	"github.com/go-openapi/runtime"
@@ -25,6 +28,7 @@ var (
 	usersstat        = make(map[string]int32)
 	statuslock       = &sync.RWMutex{}
 	responseMediator swagsock.ResponseMediator
+	protocolHandler  swagsock.ProtocolHandler
 )
 
 func getChatSummary() (int32, []string) {
@@ -68,12 +72,25 @@ func configureAPI(api *operations.ChatMultiroomsDemoAPI) http.Handler {
 		updateChatSummary(params.Name)
 		payload := &models.Message{Name: params.Name, Room: params.Room, Text: params.Body.Text, Type: "message"}
 		pb, _ := payload.MarshalBinary()
-		responseMediator.WriteTopic(params.Room, pb)
+		// the author receives the message in the response
+		if _, err := responseMediator.WriteTopicExcept(params.Room, pb, swagsock.GetRequestKey(params.HTTPRequest)); err != nil {
+			if aerr, ok := err.(*swagsock.AuthorizationError); ok {
+				return &errorResp{int(aerr.Code()), aerr.Error(), make(http.Header)}
+			}
+			return &errorResp{http.StatusBadRequest, err.Error(), make(http.Header)}
+		}
 		return operations.NewChatOK().WithPayload(payload)
 	})
 
 	api.RoomMembersHandler = operations.RoomMembersHandlerFunc(func(params operations.RoomMembersParams) middleware.Responder {
-		return operations.NewRoomMembersOK().WithPayload(responseMediator.SubscribedTopic(params.Room))
+		members, err := responseMediator.SubscribedTopicFor(swagsock.GetRequestKey(params.HTTPRequest), params.Room)
+		if err != nil {
+			if aerr, ok := err.(*swagsock.AuthorizationError); ok {
+				return &errorResp{int(aerr.Code()), aerr.Error(), make(http.Header)}
+			}
+			return &errorResp{http.StatusBadRequest, err.Error(), make(http.Header)}
+		}
+		return operations.NewRoomMembersOK().WithPayload(members)
 	})
 
 	api.RoomsHandler = operations.RoomsHandlerFunc(func(params operations.RoomsParams) middleware.Responder {
@@ -81,11 +98,12 @@ func configureAPI(api *operations.ChatMultiroomsDemoAPI) http.Handler {
 	})
 
 	api.SubscribeHandler = operations.SubscribeHandlerFunc(func(params operations.SubscribeParams) middleware.Responder {
-		hello := &models.Message{Name: params.Name, Room: params.Room, Type: "joined"}
-		hb, _ := hello.MarshalBinary()
-		bye := &models.Message{Name: params.Name, Room: params.Room, Type: "left"}
-		bb, _ := bye.MarshalBinary()
-		responder := responseMediator.SubscribeTopic(swagsock.GetRequestKey(params.HTTPRequest), params.Room, params.Name, operations.NewSubscribeOK().WithPayload(&models.Message{}), hb, bb)
+		var opts []swagsock.SubscribeOption
+		if since, err := strconv.ParseUint(params.HTTPRequest.URL.Query().Get("since"), 10, 64); err == nil {
+			// replay the messages missed since the given sequence number
+			opts = append(opts, swagsock.SubscribeOptionSince(since))
+		}
+		responder := responseMediator.SubscribeTopic(swagsock.GetRequestKey(params.HTTPRequest), params.Room, params.Name, operations.NewSubscribeOK().WithPayload(&models.Message{}), nil, nil, opts...)
 		return responder
 	})
 
@@ -95,11 +113,27 @@ func configureAPI(api *operations.ChatMultiroomsDemoAPI) http.Handler {
 		return operations.NewUnsubscribeOK().WithPayload(&models.Summary{Authors: cnames, Total: total})
 	})
 
-	api.ServerShutdown = func() {}
+	api.ServerShutdown = func() {
+		// close the websocket connections gracefully
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := protocolHandler.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down the protocol handler: %v", err)
+		}
+	}
 
 	return setupGlobalMiddleware(api.Serve(setupMiddlewares))
 }
 
+// encodePresence encodes the presence events of the rooms as the joined and left messages
+func encodePresence(event *swagsock.PresenceEvent) ([]byte, error) {
+	msg := &models.Message{Name: event.Name, Room: event.Topic, Type: "joined"}
+	if event.Type == swagsock.PresenceLeave {
+		msg.Type = "left"
+	}
+	return msg.MarshalBinary()
+}
+
 // The TLS configuration before HTTPS server starts.
 func configureTLS(tlsConfig *tls.Config) {
 	// Make all necessary changes to the TLS configuration here.
@@ -131,9 +165,11 @@ func globalMiddleware(handler http.Handler) http.Handler {
 	conf := swagsock.NewConfig()
 	conf.Heartbeat = 30
 	conf.Log = log.New(os.Stdout, "[swagsocket] ", log.LstdFlags)
+	conf.ResponseMediator = swagsock.NewDefaultResponseMediator(swagsock.MediatorOptionHistory(swagsock.NewMemoryHistoryStore(100)),
+		swagsock.MediatorOptionPresence(encodePresence))
 	responseMediator = conf.ResponseMediator
 
-	protocolHandler := swagsock.CreateProtocolHandler(conf)
+	protocolHandler = swagsock.CreateProtocolHandler(conf)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// use the protocol handler to handle websocket requests
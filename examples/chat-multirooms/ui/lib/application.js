@@ -258,7 +258,13 @@ $(function () {
 
     function doChat(name, room, content) {
         var req = socket.request().pathpattern("/v1/chat/{name}/{room}").pathparam("name", name).pathparam("room", room).method("POST").content(content, "application/json");
-        socket.send(req);
+        socket.send(req, function (header, content) {
+            if (header.code === 200) {
+                // the own message is not pushed via the subscription
+                var body = JSON.parse(content);
+                addMessage(body.name, body.room, body.text, 'blue', new Date());
+            }
+        });
         return req.getrequestid();
     }
 
@@ -3,12 +3,14 @@
 package restapi
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-openapi/errors"
 	"github.com/go-openapi/runtime"
@@ -25,6 +27,7 @@ var (
 	usersstat        = make(map[string]int32)
 	statuslock       = &sync.RWMutex{}
 	responseMediator swagsock.ResponseMediator
+	protocolHandler  swagsock.ProtocolHandler
 )
 
 func getChatSummary() (int32, []string) {
@@ -88,7 +91,14 @@ func configureAPI(api *operations.ChatDemoAPI) http.Handler {
 		return operations.NewUnsubscribeOK().WithPayload(&models.Summary{Authors: cnames, Total: total})
 	})
 
-	api.ServerShutdown = func() {}
+	api.ServerShutdown = func() {
+		// close the websocket connections gracefully
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := protocolHandler.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down the protocol handler: %v", err)
+		}
+	}
 
 	return setupGlobalMiddleware(api.Serve(setupMiddlewares))
 }
@@ -126,7 +136,7 @@ func globalMiddleware(handler http.Handler) http.Handler {
 	conf.Log = log.New(os.Stdout, "[swagsocket] ", log.LstdFlags)
 	responseMediator = conf.ResponseMediator
 
-	protocolHandler := swagsock.CreateProtocolHandler(conf)
+	protocolHandler = swagsock.CreateProtocolHandler(conf)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// use the protocol handler to handle websocket requests
@@ -129,6 +129,10 @@
                                             delete _continued[header.id];
                                         }
                                         callback(header, content);
+                                        if (header.end) {
+                                            // the subscription has ended
+                                            delete _subscribed[header.id];
+                                        }
                                         if (!(header.id in _subscribed)) {
                                             delete _callbacks[header.id];
                                         }
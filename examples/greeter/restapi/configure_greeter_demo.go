@@ -3,6 +3,7 @@
 package restapi
 
 import (
+	"context"
 	"crypto/tls"
 	"io/ioutil"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/elakito/swagsock/examples/greeter/models"
 	"github.com/elakito/swagsock/examples/greeter/restapi/operations"
@@ -29,6 +31,7 @@ var (
 	greeted          = make(map[string]int32)
 	statuslock       = &sync.RWMutex{}
 	responseMediator swagsock.ResponseMediator
+	protocolHandler  swagsock.ProtocolHandler
 )
 
 func getGreetSummary() (int32, []string) {
@@ -95,8 +98,7 @@ func configureAPI(api *operations.GreeterDemoAPI) http.Handler {
 		}
 		updateGreet(params.Name)
 		payload := &models.GreetingReply{From: params.Name, Name: params.Body.Name, Text: params.Body.Text}
-		pb, _ := payload.MarshalBinary()
-		responseMediator.Write(params.Body.Name, pb)
+		responseMediator.WriteObject(params.Body.Name, payload)
 		return operations.NewGreetOK().WithPayload(payload)
 	})
 	api.PingHandler = operations.PingHandlerFunc(func(params operations.PingParams) middleware.Responder {
@@ -121,7 +123,14 @@ func configureAPI(api *operations.GreeterDemoAPI) http.Handler {
 		return operations.NewGetGreetSummaryOK().WithPayload(&models.GreetingSummary{Greeted: gnames, Total: total})
 	})
 
-	api.ServerShutdown = func() {}
+	api.ServerShutdown = func() {
+		// close the websocket connections gracefully
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := protocolHandler.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down the protocol handler: %v", err)
+		}
+	}
 
 	return setupGlobalMiddleware(api.Serve(setupMiddlewares))
 }
@@ -159,7 +168,7 @@ func globalMiddleware(handler http.Handler) http.Handler {
 	conf.Log = log.New(os.Stdout, "[swagsocket] ", log.LstdFlags)
 	responseMediator = conf.ResponseMediator
 
-	protocolHandler := swagsock.CreateProtocolHandler(conf)
+	protocolHandler = swagsock.CreateProtocolHandler(conf)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// use the protocol handler to handle websocket requests
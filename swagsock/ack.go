@@ -0,0 +1,172 @@
+package swagsock
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultAckTimeout is the duration after which an unacknowledged message is redelivered
+	defaultAckTimeout = 30 * time.Second
+	// defaultMaxUnacked is the number of the unacknowledged messages of a subscription beyond which it is evicted
+	defaultMaxUnacked = 1000
+	// defaultMaxRedeliveries is the number of the redeliveries of a message after which its subscription is evicted
+	defaultMaxRedeliveries = 10
+)
+
+var errTooManyUnacked = errors.New("too_many_unacknowledged")
+
+// MediatorOptionAckTimeout sets the duration after which an unacknowledged message written to a subscription made
+// using SubscribeOptionAcknowledged is redelivered
+func MediatorOptionAckTimeout(timeout time.Duration) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.ackTimeout = timeout
+	}
+}
+
+// MediatorOptionAckLimits sets the number of the unacknowledged messages kept for a subscription made using
+// SubscribeOptionAcknowledged and the number of the redeliveries of each message. The subscriber exceeding either limit
+// is evicted as if its writes failed. No limit applies when its value is 0
+func MediatorOptionAckLimits(maxUnacked int, maxRedeliveries int) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.maxUnacked = maxUnacked
+		m.maxRedeliveries = maxRedeliveries
+	}
+}
+
+// deliveryAcknowledger is implemented by the mediator to receive the acknowledgements of the delivered messages
+type deliveryAcknowledger interface {
+	acknowledge(key string, did uint64)
+}
+
+// pendingDelivery is a message written to an acknowledged subscription that is not yet acknowledged
+type pendingDelivery struct {
+	headers map[string]interface{}
+	data    []byte
+	timer   *time.Timer
+	// the number of the redeliveries
+	redelivered int
+}
+
+// writeAcknowledged writes the message with a new delivery id and keeps it until it is acknowledged
+func (m *defaultResponseMediator) writeAcknowledged(r *ReusableResponder, w http.ResponseWriter, headers map[string]interface{}, data []byte) error {
+	did := atomic.AddUint64(&m.nextDid, 1)
+	dheaders := make(map[string]interface{}, len(headers)+2)
+	for k, v := range headers {
+		dheaders[k] = v
+	}
+	dheaders["did"] = did
+	dheaders["epoch"] = m.epoch
+	pd := &pendingDelivery{headers: dheaders, data: data}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m.maxUnacked > 0 && len(r.unacked) >= m.maxUnacked {
+		go m.evictUnacknowledged(r.key, r)
+		return errTooManyUnacked
+	}
+	if r.unacked == nil {
+		r.unacked = make(map[uint64]*pendingDelivery)
+	}
+	r.unacked[did] = pd
+	key := r.key
+	pd.timer = time.AfterFunc(m.ackTimeout, func() {
+		m.redeliver(key, did)
+	})
+	// the message is redelivered after the timeout if this write fails
+	_, err := r.writeWithHeadersTo(w, dheaders, data)
+	return err
+}
+
+func (m *defaultResponseMediator) acknowledge(key string, did uint64) {
+	m.RLock()
+	defer m.RUnlock()
+	if r, ok := m.responders[key]; ok {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if pd, ok := r.unacked[did]; ok {
+			pd.timer.Stop()
+			delete(r.unacked, did)
+		}
+	}
+}
+
+// redeliver writes the unacknowledged message again unless the subscriber is offline
+func (m *defaultResponseMediator) redeliver(key string, did uint64) {
+	out := &outbox{}
+	defer m.send(out, nil)
+	m.RLock()
+	defer m.RUnlock()
+	if r, ok := m.responders[key]; ok {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if pd, ok := r.unacked[did]; ok {
+			m.writePending(r, pd, out)
+		}
+	}
+}
+
+// redeliverAll posts all the unacknowledged messages again in their original order. The caller must hold the
+// mediator's exclusive lock
+func (m *defaultResponseMediator) redeliverAll(r *ReusableResponder) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	dids := make([]uint64, 0, len(r.unacked))
+	for did := range r.unacked {
+		dids = append(dids, did)
+	}
+	sort.Slice(dids, func(i, j int) bool {
+		return dids[i] < dids[j]
+	})
+	for _, did := range dids {
+		m.writePending(r, r.unacked[did], &m.pending)
+	}
+}
+
+// writePending posts the unacknowledged message to the outbox and rearms its redelivery. The caller must hold r.lock
+func (m *defaultResponseMediator) writePending(r *ReusableResponder, pd *pendingDelivery, out *outbox) {
+	if !r.offline && r.writer != nil {
+		if m.maxRedeliveries > 0 && pd.redelivered >= m.maxRedeliveries {
+			go m.evictUnacknowledged(r.key, r)
+			return
+		}
+		pd.redelivered++
+		out.post(&outgoing{r: r, writer: r.writer, headers: pd.headers, data: pd.data, kind: outgoingPush})
+	}
+	pd.timer.Reset(m.ackTimeout)
+}
+
+// stopDeliveries discards the unacknowledged and throttled messages and stops the expiry of the removed subscription
+func (r *ReusableResponder) stopDeliveries() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.discardUnacked()
+	r.stopThrottles()
+	r.stopExpiry()
+}
+
+// discardUnacked discards the unacknowledged messages. The caller holds r.lock
+func (r *ReusableResponder) discardUnacked() {
+	for _, pd := range r.unacked {
+		pd.timer.Stop()
+	}
+	r.unacked = nil
+}
+
+// evictUnacknowledged evicts the subscriber which does not acknowledge its messages. The unacknowledged messages of
+// the durable subscriber kept offline are discarded
+func (m *defaultResponseMediator) evictUnacknowledged(key string, r *ReusableResponder) {
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	if m.responders[key] != r || r.offline {
+		return
+	}
+	m.log.Printf("evicting subscriber %s not acknowledging its messages", key)
+	m.evict(key, r)
+	r.lock.Lock()
+	r.discardUnacked()
+	r.lock.Unlock()
+}
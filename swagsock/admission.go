@@ -0,0 +1,166 @@
+package swagsock
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdleUpgradeBuckets is the number of the upgrade rate buckets above which the refilled buckets are removed
+const maxIdleUpgradeBuckets = 1024
+
+// admission limits the connections admitted to the protocol handler. The slots are reserved before upgrading the
+// connections and released when the connections are removed
+type admission struct {
+	maxConnections       int
+	maxPerIP             int
+	maxPerPrincipal      int
+	upgradeRate          float64
+	upgradeBurst         int
+	connections          int
+	perIP                map[string]int
+	perPrincipal         map[string]int
+	buckets              map[string]*upgradeBucket
+	rejectedConnections  uint64
+	rejectedPerIP        uint64
+	rejectedPerPrincipal uint64
+	rejectedRate         uint64
+	lock                 sync.Mutex
+}
+
+// upgradeBucket is the token bucket of the upgrade requests of a remote IP
+type upgradeBucket struct {
+	tokens float64
+	refill time.Time
+}
+
+func newAdmission(conf *Config) *admission {
+	a := &admission{maxConnections: conf.MaxConnections, maxPerIP: conf.MaxConnectionsPerIP, maxPerPrincipal: conf.MaxConnectionsPerPrincipal,
+		upgradeRate: conf.UpgradeRatePerIP, upgradeBurst: conf.UpgradeBurstPerIP,
+		perIP: make(map[string]int), perPrincipal: make(map[string]int), buckets: make(map[string]*upgradeBucket)}
+	if a.upgradeBurst < 1 {
+		a.upgradeBurst = 1
+	}
+	return a
+}
+
+// admit reserves a slot for the connection of the remote IP and principal. It returns 0 if the connection is admitted,
+// otherwise the http status code to reject it with and the duration after which the client may retry
+func (a *admission) admit(ip string, principal string) (int, time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if wait := a.take(ip, time.Now()); wait > 0 {
+		a.rejectedRate++
+		return http.StatusTooManyRequests, wait
+	}
+	if a.maxConnections > 0 && a.connections >= a.maxConnections {
+		a.rejectedConnections++
+		return http.StatusServiceUnavailable, 0
+	}
+	if a.maxPerIP > 0 && a.perIP[ip] >= a.maxPerIP {
+		a.rejectedPerIP++
+		return http.StatusTooManyRequests, 0
+	}
+	// the anonymous connections are not limited per principal
+	if a.maxPerPrincipal > 0 && principal != "" && a.perPrincipal[principal] >= a.maxPerPrincipal {
+		a.rejectedPerPrincipal++
+		return http.StatusTooManyRequests, 0
+	}
+	a.connections++
+	a.perIP[ip]++
+	if principal != "" {
+		a.perPrincipal[principal]++
+	}
+	return 0, 0
+}
+
+// release frees the slot reserved for the connection of the remote IP and principal
+func (a *admission) release(ip string, principal string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.connections--
+	if a.perIP[ip]--; a.perIP[ip] <= 0 {
+		delete(a.perIP, ip)
+	}
+	if principal == "" {
+		return
+	}
+	if a.perPrincipal[principal]--; a.perPrincipal[principal] <= 0 {
+		delete(a.perPrincipal, principal)
+	}
+}
+
+// take takes a token from the upgrade bucket of the remote IP and returns 0, or returns the duration until the next
+// token is available. The caller holds the lock
+func (a *admission) take(ip string, now time.Time) time.Duration {
+	if a.upgradeRate <= 0 {
+		return 0
+	}
+	b, ok := a.buckets[ip]
+	if !ok {
+		if len(a.buckets) >= maxIdleUpgradeBuckets {
+			a.prune(now)
+		}
+		b = &upgradeBucket{tokens: float64(a.upgradeBurst), refill: now}
+		a.buckets[ip] = b
+	}
+	b.tokens = math.Min(b.tokens+now.Sub(b.refill).Seconds()*a.upgradeRate, float64(a.upgradeBurst))
+	b.refill = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / a.upgradeRate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// prune removes the buckets which would be full by now. The caller holds the lock
+func (a *admission) prune(now time.Time) {
+	for ip, b := range a.buckets {
+		if b.tokens+now.Sub(b.refill).Seconds()*a.upgradeRate >= float64(a.upgradeBurst) {
+			delete(a.buckets, ip)
+		}
+	}
+}
+
+// stats returns the snapshot of the rejection counts
+func (a *admission) stats() *AdmissionStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return &AdmissionStats{Connections: a.connections, RejectedConnections: a.rejectedConnections, RejectedPerIP: a.rejectedPerIP,
+		RejectedPerPrincipal: a.rejectedPerPrincipal, RejectedRate: a.rejectedRate}
+}
+
+func (ph *protocolHandler) Admissions() *AdmissionStats {
+	return ph.admission.stats()
+}
+
+// admitConnection reserves a slot for the upgrade request or rejects it with 429 or 503 and returns false
+func (ph *protocolHandler) admitConnection(w http.ResponseWriter, r *http.Request) bool {
+	code, wait := ph.admission.admit(remoteIP(r), ph.principal(r))
+	if code == 0 {
+		return true
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	ph.log.Printf("rejected connection from %s with %d", r.RemoteAddr, code)
+	http.Error(w, http.StatusText(code), code)
+	return false
+}
+
+// releaseConnection frees the slot reserved for the upgrade request
+func (ph *protocolHandler) releaseConnection(r *http.Request, principal string) {
+	ph.admission.release(remoteIP(r), principal)
+}
+
+// remoteIP returns the IP address of the client of the request
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
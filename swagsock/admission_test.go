@@ -0,0 +1,75 @@
+package swagsock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(&Config{MaxConnections: 3, MaxConnectionsPerIP: 2, MaxConnectionsPerPrincipal: 1})
+	code, _ := a.admit("10.0.0.1", "naranja")
+	assert.Equal(t, 0, code)
+	code, _ = a.admit("10.0.0.1", "naranja")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	code, _ = a.admit("10.0.0.1", "")
+	assert.Equal(t, 0, code)
+	code, _ = a.admit("10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	code, _ = a.admit("10.0.0.2", "")
+	assert.Equal(t, 0, code)
+	code, _ = a.admit("10.0.0.3", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	a.release("10.0.0.1", "naranja")
+	code, _ = a.admit("10.0.0.3", "manzana")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, &AdmissionStats{Connections: 3, RejectedConnections: 1, RejectedPerIP: 1, RejectedPerPrincipal: 1}, a.stats())
+}
+
+func TestAdmissionUpgradeRate(t *testing.T) {
+	a := newAdmission(&Config{UpgradeRatePerIP: 2, UpgradeBurstPerIP: 2})
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), a.take("10.0.0.1", now))
+	assert.Equal(t, time.Duration(0), a.take("10.0.0.1", now))
+	assert.Equal(t, 500*time.Millisecond, a.take("10.0.0.1", now))
+	assert.Equal(t, time.Duration(0), a.take("10.0.0.2", now))
+
+	// a token is refilled after 500ms
+	assert.Equal(t, time.Duration(0), a.take("10.0.0.1", now.Add(500*time.Millisecond)))
+
+	// the full buckets are pruned
+	a.prune(now.Add(2 * time.Second))
+	assert.Empty(t, a.buckets)
+}
+
+func TestServeAdmission(t *testing.T) {
+	conf := NewConfig()
+	conf.MaxConnectionsPerIP = 1
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(&testEchoHandler{mediator: conf.ResponseMediator}, w, r)
+	}))
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"?x-tracking-id="+testTrackingID, nil)
+	assert.NoError(t, err)
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, &AdmissionStats{Connections: 1, RejectedPerIP: 1}, ph.Admissions())
+
+	// the slot is released when the connection is closed
+	ws.Close()
+	for i := 0; i < 100 && ph.Admissions().Connections > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ws, _, err = websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+	assert.NoError(t, err)
+	defer ws.Close() //nolint:staticcheck
+}
@@ -1,8 +1,11 @@
 package swagsock
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-openapi/runtime"
 	"github.com/go-openapi/runtime/middleware"
@@ -24,29 +27,334 @@ type ProtocolHandler interface {
 	Serve(handler http.Handler, w http.ResponseWriter, r *http.Request)
 	// Destroy the handler
 	Destroy()
+	// Connections returns the snapshot of the connected clients ordered by their connection time
+	Connections() []*ConnectionInfo
+	// Disconnect closes the connection identified by the tracking ID with the websocket close code and reason
+	Disconnect(trackingID string, code int, reason string) error
+	// Call sends the request to the client identified by the tracking ID and waits for its response until the context
+	// is done. The client serves the request with the handler registered to its ClientTransport
+	Call(ctx context.Context, trackingID string, r *http.Request) (*http.Response, error)
+	// Shutdown stops accepting new connections and requests, advises the clients to reconnect elsewhere, waits for the
+	// requests being served, and closes the connections with CloseGoingAway. The remaining connections are closed
+	// forcibly and the context's error is returned when the context is done before the connections are closed
+	Shutdown(ctx context.Context) error
+	// Admissions returns the number of the admitted connections and the counts of the upgrade requests rejected by
+	// the connection limits of the configuration
+	Admissions() *AdmissionStats
 }
 
 // ResponseMediator is the interface to manage responders and delivery of responses to the subscribers
 type ResponseMediator interface {
 	// Subscribe subscribes to the specified name. Parameters responder, hello, and bye represent the responseWriter sent
-	// to the requester, the optional hello and bye messages sent to the subscribers.
-	Subscribe(key string, name string, responder middleware.Responder, hello []byte, bye []byte) middleware.Responder
-	SubscribeTopic(key string, topic string, name string, responder middleware.Responder, hello []byte, bye []byte) middleware.Responder
+	// to the requester, the optional hello and bye messages sent to the subscribers. Parameter opts represents the optional
+	// subscription settings such as SubscribeOptionFilter.
+	Subscribe(key string, name string, responder middleware.Responder, hello []byte, bye []byte, opts ...SubscribeOption) middleware.Responder
+	// SubscribeTopic subscribes to the specified topic. The topic may be a hierarchical topic filter whose levels are
+	// separated by '/' and which may contain the single-level wildcard '+' and the multi-level wildcard '#' as its last level.
+	// The optional hello and bye messages are sent to the topic subscribers (see MediatorOptionScopedGreetings).
+	SubscribeTopic(key string, topic string, name string, responder middleware.Responder, hello []byte, bye []byte, opts ...SubscribeOption) middleware.Responder
+
+	// JoinTopic adds the specified topic or topic filter to the subscription associated with the subscription id
+	// (i.e., the request id used for the subscription). Messages pushed from a topic carry the topic in their headers.
+	JoinTopic(key string, subid string, topic string) error
+	// LeaveTopic removes the specified topic or topic filter from the subscription associated with the subscription id.
+	LeaveTopic(key string, subid string, topic string) error
 
 	// Unsubscribe cancels the subscription associated with the subscription id (i.e., the request id used for the subscription)
 	Unsubscribe(key string, subid string)
 
 	// UnsubscribeAll cancels all the subscriptions associated with the swaggersocket key.
 	UnsubscribeAll(key string)
+	// UnsubscribeMatching cancels the subscriptions selected by the filter, including the durable ones, and returns
+	// their number. This is meant for the administrative removal of subscriptions by name, topic, or tracking ID.
+	UnsubscribeMatching(filter SubscriptionFilter) int
 
 	// Subscribed returns the list of active subscriber names.
 	Subscribed() []string
+	// SubscribedTopics returns the list of subscribed topics and topic filters.
 	SubscribedTopics() []string
+	// SubscribedTopic returns the list of subscriber names whose topic filters match the specified topic. The list is
+	// nil and the denial is logged if the authorizer denies listing the topic.
 	SubscribedTopic(topic string) []string
+	// SubscribedTopicFor returns the list like SubscribedTopic for the connection identified by the request key, which
+	// the authorizer is consulted for, and returns the AuthorizationError if listing the topic is denied.
+	SubscribedTopicFor(key string, topic string) ([]string, error)
+	// Subscriptions returns the subscriptions selected by the filter in the order of their creation.
+	Subscriptions(filter SubscriptionFilter) []*SubscriptionInfo
+
+	// Write writes to the specified subscriber or to all subscribers when name is '*' and reports the delivery.
+	Write(name string, data []byte) (*DeliveryReport, error)
+	// WriteFrom writes like Write on behalf of the connection identified by the request key, which the authorizer is
+	// consulted for.
+	WriteFrom(key string, name string, data []byte) (*DeliveryReport, error)
+	// WriteTopic writes to the subscribers whose topic filters match the specified topic or to all topic subscribers
+	// when topic is '*' and reports the delivery.
+	WriteTopic(topic string, data []byte) (*DeliveryReport, error)
+	// WriteTopicFrom writes like WriteTopic on behalf of the connection identified by the request key, which the
+	// authorizer is consulted for.
+	WriteTopicFrom(key string, topic string, data []byte) (*DeliveryReport, error)
+	// WriteExcept writes like Write except to the subscribers of the connection identified by the tracking ID or by a
+	// request key of that connection such as the one returned by GetRequestKey, typically the sender's.
+	WriteExcept(name string, data []byte, except string) (*DeliveryReport, error)
+	// WriteTopicExcept writes like WriteTopic except to the subscribers of the connection identified like in WriteExcept.
+	WriteTopicExcept(topic string, data []byte, except string) (*DeliveryReport, error)
+	// WriteTo writes to all the subscriptions of the connection identified by the tracking ID or by a request key of
+	// that connection such as the one returned by GetRequestKey.
+	WriteTo(trackingID string, data []byte) (*DeliveryReport, error)
+	// WriteObject writes the object like Write, encoding it for each subscriber using the producer negotiated for its
+	// initial response and setting the message's type to the subscriber's media type. The object is not recorded in the history.
+	WriteObject(name string, v interface{}) (*DeliveryReport, error)
+	// WriteTopicObject writes the object like WriteTopic, encoding it for each subscriber like WriteObject.
+	WriteTopicObject(topic string, v interface{}) (*DeliveryReport, error)
+	// WriteTopicObjectFrom writes the object like WriteTopicObject on behalf of the connection identified by the request key.
+	WriteTopicObjectFrom(key string, topic string, v interface{}) (*DeliveryReport, error)
+	// WriteTopicRetained writes to the topic subscribers like WriteTopic and retains the data as the topic's last value
+	// which is delivered to each new subscriber of the topic right after its initial response. The retained data expires
+	// after the specified ttl unless ttl is 0. Empty data clears the retained data.
+	WriteTopicRetained(topic string, data []byte, ttl time.Duration) error
+	// WriteTopicRetainedFrom writes and retains the data like WriteTopicRetained on behalf of the connection identified
+	// by the request key.
+	WriteTopicRetainedFrom(key string, topic string, data []byte, ttl time.Duration) error
+	// ClearRetained clears the data retained for the specified topic.
+	ClearRetained(topic string)
+
+	// Presence returns the present members of the specified topic, topic filter, or of the name subscriptions when
+	// topic is empty, in the order of their joining.
+	Presence(topic string) []*PresenceMember
+}
+
+// SubscribeOption represents an optional setting of a subscription
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	filter       Filter
+	since        *uint64
+	durable      bool
+	acknowledged bool
+	metadata     map[string]interface{}
+	policy       *DeliveryPolicy
+	ttl          time.Duration
+	idleTimeout  time.Duration
+}
+
+func newSubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SubscribeOptionFilter sets the filter that selects the messages delivered to the subscription. No filtering takes place if filter is nil
+func SubscribeOptionFilter(filter Filter) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.filter = filter
+	}
+}
+
+// SubscribeOptionSince requests the messages of the subscribed topic or name following the specified sequence number
+// to be replayed from the mediator's history before the live messages are delivered
+func SubscribeOptionSince(seq uint64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.since = &seq
+	}
+}
+
+// SubscribeOptionDurable makes the subscription durable when the mediator has a SubscriptionStore. A durable subscription
+// outlives the disconnection of its subscriber and server restarts. Its messages are queued while the subscriber is offline
+// and delivered after the subscriber reconnects with the same tracking ID. The subscription's filter is not persisted.
+// The option is ignored if the mediator has no SubscriptionStore
+func SubscribeOptionDurable() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.durable = true
+	}
+}
+
+// SubscribeOptionAcknowledged enables the at-least-once delivery for the subscription. Each message written to the
+// subscription carries a delivery id and is redelivered until the subscriber acknowledges this delivery id
+func SubscribeOptionAcknowledged() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.acknowledged = true
+	}
+}
+
+// SubscribeOptionPresence sets the application's metadata of the subscriber which is included in its presence events
+// and in the presence members returned by the mediator
+func SubscribeOptionPresence(metadata map[string]interface{}) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.metadata = metadata
+	}
+}
+
+const (
+	// EndReasonUnsubscribed is the reason of the terminal message of a subscription that is unsubscribed
+	EndReasonUnsubscribed = "unsubscribed"
+	// EndReasonTTL is the reason of the terminal message of a subscription that reached its time-to-live
+	EndReasonTTL = "ttl"
+	// EndReasonIdle is the reason of the terminal message of a subscription that received no messages within its idle timeout
+	EndReasonIdle = "idle"
+)
+
+// SubscribeOptionPolicy sets the delivery policy of the subscription's messages, which overrides the policy of the
+// topic set by MediatorOptionTopicPolicy
+func SubscribeOptionPolicy(policy *DeliveryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = policy
+	}
+}
+
+// Observer is notified of the subscription lifecycle. The notifications are sent outside the mediator lock one at a time
+// in the order of the changes, so an observer may call the mediator
+type Observer interface {
+	// OnSubscribe is called when a subscription is made
+	OnSubscribe(sub *SubscriptionInfo)
+	// OnUnsubscribe is called when a subscription is cancelled, including the cancellations of UnsubscribeAll and evictions
+	OnUnsubscribe(sub *SubscriptionInfo)
+	// OnTopicCreated is called when the first subscription joins the topic or topic filter
+	OnTopicCreated(topic string)
+	// OnTopicEmpty is called when the last subscription leaves the topic or topic filter
+	OnTopicEmpty(topic string)
+	// OnConnectionGone is called when the subscriptions of the connection are cancelled by UnsubscribeAll
+	OnConnectionGone(trackingID string)
+}
+
+// SubscriptionFilter selects the subscriptions whose name, tracking ID, and topic equal the non-empty fields of the filter.
+// Field Topic selects the subscriptions that joined the exact topic or topic filter
+type SubscriptionFilter struct {
+	Name       string
+	Topic      string
+	TrackingID string
+}
+
+// SubscriptionInfo describes a subscription and its delivery statistics
+type SubscriptionInfo struct {
+	Key          string    `json:"key"`
+	TrackingID   string    `json:"trackingID"`
+	RequestID    string    `json:"requestID"`
+	Name         string    `json:"name"`
+	Topics       []string  `json:"topics"`
+	Created      time.Time `json:"created"`
+	Delivered    uint64    `json:"delivered"`
+	Failed       uint64    `json:"failed"`
+	LastDelivery time.Time `json:"lastDelivery,omitempty"`
+	Durable      bool      `json:"durable,omitempty"`
+	Offline      bool      `json:"offline,omitempty"`
+}
+
+// ConnectionInfo describes a client connection, its message counts, and its subscriptions
+type ConnectionInfo struct {
+	TrackingID    string              `json:"trackingID"`
+	RemoteAddr    string              `json:"remoteAddr"`
+	UserAgent     string              `json:"userAgent,omitempty"`
+	Connected     time.Time           `json:"connected"`
+	Principal     string              `json:"principal,omitempty"`
+	Received      uint64              `json:"received"`
+	Sent          uint64              `json:"sent"`
+	Subscriptions []*SubscriptionInfo `json:"subscriptions"`
+}
+
+// AdmissionStats describes the admitted connections and the counts of the rejected upgrade requests by their limits
+type AdmissionStats struct {
+	Connections          int    `json:"connections"`
+	RejectedConnections  uint64 `json:"rejectedConnections"`
+	RejectedPerIP        uint64 `json:"rejectedPerIP"`
+	RejectedPerPrincipal uint64 `json:"rejectedPerPrincipal"`
+	RejectedRate         uint64 `json:"rejectedRate"`
+}
+
+// DeliveryReport is the outcome of writing a message to the subscribers
+type DeliveryReport struct {
+	// the number of subscribers to which the message was written or for which it was queued while offline
+	Delivered int
+	// the number of subscribers for which the message was held back by the delivery policy to be delivered later or
+	// to be conflated with a later message
+	Throttled int
+	// the number of subscribers for which the message was dropped by the delivery policy
+	Dropped int
+	// the number of subscribers to which the message could not be written
+	Failed int
+	// the number of subscribers evicted after too many consecutive write failures
+	Evicted int
+	// the errors of the failed subscribers
+	Errors []*SubscriberError
+}
+
+// SubscriberError is the error of writing a message to a subscriber
+type SubscriberError struct {
+	Name           string
+	TrackingID     string
+	SubscriptionID string
+	Err            error
+}
+
+func (e *SubscriberError) Error() string {
+	return fmt.Sprintf("subscriber %s (%s#%s): %s", e.Name, e.TrackingID, e.SubscriptionID, e.Err.Error())
+}
+
+// PresenceEvent is raised when a subscriber joins or leaves a topic or, for a subscription without topics, its name
+type PresenceEvent struct {
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	Topic      string                 `json:"topic,omitempty"`
+	TrackingID string                 `json:"trackingID"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// PresenceMember is a present subscriber of a topic or name
+type PresenceMember struct {
+	Name       string                 `json:"name"`
+	TrackingID string                 `json:"trackingID"`
+	Joined     time.Time              `json:"joined"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// PresenceEncoder encodes the presence event into the message broadcast to the subscribers in the event's scope.
+// The event is not broadcast when the encoder returns nil data
+type PresenceEncoder func(event *PresenceEvent) ([]byte, error)
+
+// HistoryStore is the interface to keep the messages written to the names and topics for the later replay
+type HistoryStore interface {
+	// Append appends the data to the specified stream and returns its sequence number which is increasing monotonically per stream
+	Append(stream string, data []byte) (uint64, error)
+	// Since returns the kept messages of the specified stream whose sequence numbers follow the specified sequence number
+	Since(stream string, seq uint64) ([]*HistoryMessage, error)
+}
 
-	// Write writes to the specified subscriber or to all subscribers when name is '*'.
-	Write(name string, data []byte) error
-	WriteTopic(topic string, data []byte) error
+// HistoryMessage is a message kept in the HistoryStore
+type HistoryMessage struct {
+	Seq  uint64
+	Data []byte
+}
+
+// SubscriptionStore is the interface to persist the durable subscriptions and the messages queued for them
+type SubscriptionStore interface {
+	// Save saves the durable subscription
+	Save(sub *DurableSubscription) error
+	// Delete deletes the durable subscription and its queued messages
+	Delete(trackingID string, requestID string) error
+	// LoadAll returns all the stored durable subscriptions
+	LoadAll() ([]*DurableSubscription, error)
+	// Enqueue appends the message to the queue of the durable subscription
+	Enqueue(trackingID string, requestID string, msg *QueuedMessage) error
+	// Dequeue removes and returns the queued messages of the durable subscription
+	Dequeue(trackingID string, requestID string) ([]*QueuedMessage, error)
+}
+
+// DurableSubscription is a durable subscription kept in the SubscriptionStore
+type DurableSubscription struct {
+	TrackingID string   `json:"trackingID"`
+	RequestID  string   `json:"requestID"`
+	Name       string   `json:"name"`
+	Topic      string   `json:"topic,omitempty"`
+	Topics     []string `json:"topics,omitempty"`
+	MediaType  string   `json:"mediaType,omitempty"`
+}
+
+// QueuedMessage is a message queued for an offline durable subscription
+type QueuedMessage struct {
+	Headers map[string]interface{} `json:"headers,omitempty"`
+	Data    []byte                 `json:"data"`
 }
 
 // Logger is the interface for logging
@@ -62,6 +370,88 @@ type Config struct {
 	ResponseMediator ResponseMediator
 	Heartbeat        int
 	Log              Logger
+	// Authorizer is consulted by the ResponseMediator for subscribing, publishing, and listing the subscribers
+	Authorizer TopicAuthorizer
+	// Principal returns the principal of the client from its websocket upgrade request. The user of the basic
+	// authentication is used if not set
+	Principal func(r *http.Request) string
+	// ResumeWindow is the duration during which the subscriptions of an abnormally disconnected client are kept and
+	// their messages are buffered until the client reconnects with the same tracking ID. No sessions are resumed if 0.
+	// With DuplicateReplace, the client reconnecting while its previous connection is still open takes over the session
+	// of that connection
+	ResumeWindow time.Duration
+	// DuplicateTrackingID decides how a connection is handled when its tracking ID is used by another connection
+	DuplicateTrackingID DuplicatePolicy
+	// TrackingIDGenerator returns the tracking ID of a client connecting without one. A random uuid is used if not set
+	TrackingIDGenerator func() string
+	// TrackingIDIssuer issues the tracking IDs instead of TrackingIDGenerator and verifies the tracking IDs presented
+	// by the clients, whose upgrade requests are rejected with 403 if their tracking IDs are forged or expired
+	TrackingIDIssuer TrackingIDIssuer
+	// GoAwayDelay is the delay advised to the clients in the goaway message before reconnecting during the shutdown
+	GoAwayDelay time.Duration
+	// MaxConnections limits the number of the connections. The upgrade requests exceeding it are rejected with 503
+	MaxConnections int
+	// MaxConnectionsPerIP limits the number of the connections from a remote IP. The upgrade requests exceeding it are
+	// rejected with 429
+	MaxConnectionsPerIP int
+	// MaxConnectionsPerPrincipal limits the number of the connections of a principal. The upgrade requests exceeding it
+	// are rejected with 429. The connections without a principal are not limited
+	MaxConnectionsPerPrincipal int
+	// UpgradeRatePerIP limits the upgrade requests from a remote IP per second allowing bursts of UpgradeBurstPerIP
+	// requests. The upgrade requests exceeding it are rejected with 429 and the Retry-After header
+	UpgradeRatePerIP  float64
+	UpgradeBurstPerIP int
+}
+
+// TrackingIDIssuer issues the tracking IDs and verifies them when the clients reconnect with them
+type TrackingIDIssuer interface {
+	// Issue returns a new tracking ID
+	Issue() string
+	// Verify returns an error if the tracking ID was not issued by this issuer or is no longer valid
+	Verify(trackingID string) error
+}
+
+// DuplicatePolicy represents the handling of a connection whose tracking ID is used by another connection
+type DuplicatePolicy int
+
+const (
+	// DuplicateShare accepts the connection sharing the session of the other connection, so that closing either one
+	// removes the subscriptions of both. This is the default
+	DuplicateShare DuplicatePolicy = iota
+	// DuplicateScope accepts the connection with its own session scope. Its tracking ID is suffixed with '~' and a
+	// sequence number, which is returned in the handshake response, so that its subscriptions are isolated. The
+	// clients must adopt the returned tracking ID
+	DuplicateScope
+	// DuplicateReject rejects the upgrade request of the connection with 409
+	DuplicateReject
+	// DuplicateReplace accepts the connection and closes the other connection with CloseReplaced. Within the resume
+	// window, the connection resumes the session of the other connection
+	DuplicateReplace
+)
+
+// CloseReplaced is the websocket close code of the connection replaced by another connection with its tracking ID
+const CloseReplaced = 4000
+
+// TopicAuthorizer decides whether the action on the topic or name is permitted and returns an error if it is not
+type TopicAuthorizer func(a *Authorization) error
+
+// Authorization describes the action to be authorized. Request and TrackingID identify the client connection and
+// its websocket upgrade request carrying the principal. They are empty when the action is taken by the server itself,
+// such as WriteTopic and SubscribedTopic, which unlike WriteTopicFrom and SubscribedTopicFor take no request key
+type Authorization struct {
+	Request    *http.Request
+	TrackingID string
+	Action     string
+	Topic      string
+	Name       string
+}
+
+// AuthorizationError is returned when the TopicAuthorizer denies the action. Its Code is 403
+type AuthorizationError struct {
+	Action string
+	Topic  string
+	Name   string
+	Err    error
 }
 
 // HandshakeRequest is the handshake request message that is sent from the client
@@ -74,6 +464,8 @@ type HandshakeResponse struct {
 	Version    string `json:"version"`
 	TrackingID string `json:"trackingID,omitempty"`
 	Error      string `json:"error,omitempty"`
+	// Resumed indicates that the subscriptions parked since the previous connection with the tracking ID are reattached
+	Resumed bool `json:"resumed,omitempty"`
 }
 
 // ClientTransport is the interface for submitting requests and it defines Submit or SubmitAsync for the synchronous
@@ -85,6 +477,8 @@ type ClientTransport interface {
 	SubmitAsync(*runtime.ClientOperation, func(string, interface{}), SubmitAsyncOption) (string, error)
 	//Close closes the socket
 	Close()
+	// Handle registers the handler which serves the requests sent by the server using ProtocolHandler's Call
+	Handle(handler http.Handler)
 }
 
 // SubmitAsyncMode represents one of the async submit mode none, subscribe, or unsubscribe
@@ -0,0 +1,57 @@
+package swagsock
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// ActionSubscribe is the action of subscribing to a topic or name
+	ActionSubscribe = "subscribe"
+	// ActionPublish is the action of writing to a topic or name
+	ActionPublish = "publish"
+	// ActionList is the action of listing the subscribers of a topic
+	ActionList = "list"
+)
+
+// topicAuthorizable is implemented by the mediator to consult the TopicAuthorizer configured for the protocol handler
+type topicAuthorizable interface {
+	setAuthorizer(authorizer TopicAuthorizer, connection func(trackingID string) *http.Request)
+}
+
+func (m *defaultResponseMediator) setAuthorizer(authorizer TopicAuthorizer, connection func(trackingID string) *http.Request) {
+	m.authorizer = authorizer
+	m.connection = connection
+}
+
+// authorize consults the authorizer for the action of the connection identified by the request key. An empty key
+// denotes the server itself
+func (m *defaultResponseMediator) authorize(key string, action string, topic string, name string) error {
+	if m.authorizer == nil {
+		return nil
+	}
+	a := &Authorization{Action: action, Topic: topic, Name: name}
+	if key != "" {
+		a.TrackingID = trackingIDOf(key)
+		if m.connection != nil {
+			a.Request = m.connection(a.TrackingID)
+		}
+	}
+	if err := m.authorizer(a); err != nil {
+		return &AuthorizationError{Action: action, Topic: topic, Name: name, Err: err}
+	}
+	return nil
+}
+
+func (e *AuthorizationError) Error() string {
+	target := e.Topic
+	if target == "" {
+		target = e.Name
+	}
+	return fmt.Sprintf("%s %q not authorized: %s", e.Action, target, e.Err.Error())
+}
+
+// Code returns the http status code 403 so that the error is served as forbidden
+func (e *AuthorizationError) Code() int32 {
+	return http.StatusForbidden
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,9 +26,14 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// maxDeliveredIDs is the number of the last delivery ids kept to detect the redelivered messages
+const maxDeliveredIDs = 1024
+
+var errGoingAway = errors.New("server_going_away")
+
 // NewTransport creates a new ClientTransport for swaggersocket
 func NewTransport(url string) ClientTransport {
-	t := &wstransport{url: url, codec: NewDefaultCodec(), pending: make(map[string]asyncResponse)}
+	t := &wstransport{url: url, codec: NewDefaultCodec(), pending: make(map[string]asyncResponse), delivered: make(map[deliveryID]struct{})}
 
 	t.consumers = map[string]runtime.Consumer{
 		runtime.JSONMime:    runtime.JSONConsumer(),
@@ -58,6 +64,20 @@ type wstransport struct {
 	nextid  int32
 	pending map[string]asyncResponse
 	lock    sync.RWMutex
+
+	// the delivery ids of the last received messages in the order of their arrival
+	delivered    map[deliveryID]struct{}
+	deliveredIDs []deliveryID
+	writelock    sync.Mutex
+
+	// the tracking ID assigned by the server and the handler of the requests sent by the server
+	trackingID string
+	handler    http.Handler
+
+	// set when the server of the connection is going away until the client reconnects after the advised time
+	goingAway   bool
+	reconnectAt time.Time
+	closed      bool
 }
 
 func (t *wstransport) getNextID() string {
@@ -65,27 +85,49 @@ func (t *wstransport) getNextID() string {
 }
 
 func (t *wstransport) connect() error {
-	c, _, err := websocket.DefaultDialer.Dial(t.url, nil)
+	c, _, err := websocket.DefaultDialer.Dial(t.connectURL(), nil)
 	if err != nil {
 		return err
 	}
 	// connected
+	t.writelock.Lock()
 	t.conn = c
-	if err := t.conn.WriteJSON(&HandshakeRequest{Version: ProtocolVersion}); err != nil {
+	t.goingAway = false
+	err = t.conn.WriteJSON(&HandshakeRequest{Version: ProtocolVersion})
+	t.writelock.Unlock()
+	if err != nil {
 		t.Close()
 		return err
 	}
 	go func() {
 		var handshaked bool
 		for {
-			_, message, err := t.conn.ReadMessage()
+			_, message, err := c.ReadMessage()
 			if err == nil {
 				if handshaked {
 					headers, body, err := t.codec.DecodeSwaggerSocketMessage(message)
 					if err == nil {
+						if _, requested := headers["method"]; requested {
+							// the request sent by the server
+							go t.serveRequest(headers, body)
+							continue
+						}
+						if getBoolHeader(headers, "goaway") {
+							// the server is shutting down and closes the connection after its pending requests
+							delay, _ := headers["delay"].(float64)
+							t.goAway(c, time.Duration(delay)*time.Millisecond)
+							continue
+						}
 						reqid := headers["id"].(string)
-						// only handle if there is a pending request
-						if fresp := t.removeAsyncResponse(reqid, false); fresp != nil {
+						did, acknowledged := headers["did"].(float64)
+						if acknowledged && !t.markDelivered(getStringHeader(headers, "epoch"), reqid, uint64(did)) {
+							// drop the redelivered message whose previous acknowledgement was lost
+							t.acknowledge(reqid, uint64(did))
+							continue
+						}
+						// only handle if there is a pending request, which is also removed at the end of its subscription
+						ended, _ := headers["end"].(bool)
+						if fresp := t.removeAsyncResponse(reqid, ended); fresp != nil {
 							res := &response{code: headers["code"].(int), id: reqid}
 							if mediaType, found := headers["type"].(string); found {
 								res.mediaType = mediaType
@@ -95,6 +137,9 @@ func (t *wstransport) connect() error {
 							}
 							fresp.set(res)
 						}
+						if acknowledged {
+							t.acknowledge(reqid, uint64(did))
+						}
 					}
 				} else {
 					var hr *HandshakeResponse
@@ -106,10 +151,14 @@ func (t *wstransport) connect() error {
 						log.Println("Error handshake:", hr.Error)
 						return
 					}
+					t.lock.Lock()
+					t.trackingID = hr.TrackingID
+					t.lock.Unlock()
 					handshaked = true
 				}
 			} else {
 				log.Println("Error read:", err)
+				t.disconnected(c)
 				return
 			}
 		}
@@ -118,6 +167,46 @@ func (t *wstransport) connect() error {
 	return nil
 }
 
+// connectURL returns the url to connect to, which carries the tracking ID assigned by the server when reconnecting
+func (t *wstransport) connectURL() string {
+	t.lock.RLock()
+	trackingID := t.trackingID
+	t.lock.RUnlock()
+	u, err := url.Parse(t.url)
+	if err != nil || trackingID == "" {
+		return t.url
+	}
+	q := u.Query()
+	q.Set(knownTrackingIDs[0], trackingID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// goAway stops sending the requests over the connection of the server going away, which the client reconnects after
+// the advised delay once the server has closed the connection
+func (t *wstransport) goAway(c *websocket.Conn, delay time.Duration) {
+	t.writelock.Lock()
+	defer t.writelock.Unlock()
+	if t.conn == c {
+		t.goingAway = true
+		t.reconnectAt = time.Now().Add(delay)
+	}
+}
+
+// disconnected reconnects the client if the connection is closed by the server going away
+func (t *wstransport) disconnected(c *websocket.Conn) {
+	t.writelock.Lock()
+	defer t.writelock.Unlock()
+	if t.conn != c || !t.goingAway || t.closed {
+		return
+	}
+	time.AfterFunc(time.Until(t.reconnectAt), func() {
+		if err := t.connect(); err != nil {
+			log.Printf("failed to reconnect: %s", err.Error())
+		}
+	})
+}
+
 func (t *wstransport) createRequest(reqid string, operation *runtime.ClientOperation) ([]byte, error) {
 	req := &request{
 		pathPattern: operation.PathPattern,
@@ -180,8 +269,9 @@ func (t *wstransport) Submit(operation *runtime.ClientOperation) (interface{}, e
 	fresp := newFutureResponse(reqid)
 	t.putAsyncResponse(reqid, fresp)
 
-	err = t.writeMessage(rawmessage)
+	err = t.writeRequest(rawmessage)
 	if err != nil {
+		t.removeAsyncResponse(reqid, true)
 		return nil, err
 	}
 
@@ -219,8 +309,9 @@ func (t *wstransport) SubmitAsync(operation *runtime.ClientOperation, cb func(st
 	}, sao.Is(SubmitAsyncModeSubscribe))
 	t.putAsyncResponse(reqid, fresp)
 
-	err = t.writeMessage(rawmessage)
+	err = t.writeRequest(rawmessage)
 	if err != nil {
+		t.removeAsyncResponse(reqid, true)
 		return "", err
 	}
 	return reqid, nil
@@ -244,7 +335,83 @@ func (t *wstransport) removeAsyncResponse(reqid string, force bool) asyncRespons
 	return nil
 }
 
+func (t *wstransport) Handle(handler http.Handler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handler = handler
+}
+
+// serveRequest serves the request sent by the server with the registered handler and sends back its response
+func (t *wstransport) serveRequest(headers map[string]interface{}, body []byte) {
+	reqid := getStringHeader(headers, "id")
+	t.lock.RLock()
+	handler, trackingID := t.handler, t.trackingID
+	t.lock.RUnlock()
+	resp := &bufferedResponse{header: make(http.Header), code: http.StatusOK}
+	if handler == nil {
+		resp.WriteHeader(http.StatusNotImplemented)
+	} else {
+		handler.ServeHTTP(resp, newHTTPRequest("", trackingID, reqid, headers, bytes.NewReader(body)))
+	}
+	rheaders := map[string]interface{}{"id": reqid, "code": resp.code}
+	copyHTTPHeaderToHeaders(resp.header, "Content-Type", rheaders, "type")
+	data, err := t.codec.EncodeSwaggerSocketMessage(rheaders, resp.body.Bytes())
+	if err == nil {
+		err = t.writeMessage(data)
+	}
+	if err != nil {
+		log.Println("Error response:", err)
+	}
+}
+
+// deliveryID identifies a delivered message by its subscription and its delivery id, which is unique within the epoch
+// of the server
+type deliveryID struct {
+	epoch string
+	reqid string
+	did   uint64
+}
+
+// markDelivered records the delivery id of the subscription and returns false if it has been already recorded
+func (t *wstransport) markDelivered(epoch string, reqid string, did uint64) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	id := deliveryID{epoch: epoch, reqid: reqid, did: did}
+	if _, found := t.delivered[id]; found {
+		return false
+	}
+	t.delivered[id] = struct{}{}
+	t.deliveredIDs = append(t.deliveredIDs, id)
+	if len(t.deliveredIDs) > maxDeliveredIDs {
+		delete(t.delivered, t.deliveredIDs[0])
+		t.deliveredIDs = t.deliveredIDs[1:]
+	}
+	return true
+}
+
+// acknowledge sends the acknowledgement of the delivered message to the server
+func (t *wstransport) acknowledge(reqid string, did uint64) {
+	if err := t.writeMessage([]byte(fmt.Sprintf(`{"id":%q,"ack":%d}`, reqid, did))); err != nil {
+		log.Println("Error ack:", err)
+	}
+}
+
 func (t *wstransport) writeMessage(data []byte) error {
+	return t.write(data, false)
+}
+
+// writeRequest writes the request unless the server is going away, whereas the acknowledgements and the responses to
+// the server's requests are still written by writeMessage
+func (t *wstransport) writeRequest(data []byte) error {
+	return t.write(data, true)
+}
+
+func (t *wstransport) write(data []byte, request bool) error {
+	t.writelock.Lock()
+	defer t.writelock.Unlock()
+	if request && t.goingAway {
+		return errGoingAway
+	}
 	if t.conn == nil {
 		return fmt.Errorf("not connected")
 	}
@@ -252,6 +419,9 @@ func (t *wstransport) writeMessage(data []byte) error {
 }
 
 func (t *wstransport) Close() {
+	t.writelock.Lock()
+	defer t.writelock.Unlock()
+	t.closed = true
 	if t.conn != nil {
 		t.conn.Close()
 		t.conn = nil
@@ -283,6 +453,25 @@ func (r response) Body() io.ReadCloser {
 	return r.body
 }
 
+// bufferedResponse is the http.ResponseWriter which keeps the response to the request sent by the server
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *bufferedResponse) WriteHeader(code int) {
+	r.code = code
+}
+
 type asyncResponse interface {
 	set(r *response)
 	isSticky() bool
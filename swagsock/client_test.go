@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/go-openapi/runtime"
 	cr "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
+	"github.com/gorilla/websocket"
 
 	"github.com/stretchr/testify/assert"
 
@@ -138,6 +140,128 @@ func TestClient(t *testing.T) {
 	assert.Equal(t, 0, len(conf.ResponseMediator.Subscribed()))
 }
 
+func TestClientAcknowledged(t *testing.T) {
+	acks := make(chan string, 4)
+	ts := newTestScriptedServer(t, func(ws *websocket.Conn, r *http.Request) {
+		_, _, err := ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","code":200,"type":"application/json"}{"text":"hi"}`)))
+		push := func(did int, epoch string, text string) {
+			assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"id":"1","code":200,"push":true,"did":%d,"epoch":%q,"type":"application/json"}{"text":%q}`, did, epoch, text))))
+			_, ack, err := ws.ReadMessage()
+			assert.NoError(t, err)
+			acks <- string(ack)
+		}
+		push(1, "e1", "a")
+		// the redelivered message is acknowledged again but not passed to the callback
+		push(1, "e1", "a")
+		// the delivery ids of the restarted server are not taken as redelivered
+		push(1, "e2", "b")
+		assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","code":200,"push":true,"end":true}`)))
+		ws.ReadMessage() //nolint:errcheck
+	})
+	defer ts.Close()
+
+	transport := NewTransport("ws" + ts.URL[4:])
+	defer transport.Close()
+	client := New(transport, strfmt.Default)
+	texts := make(chan string, 5)
+	subid, err := client.SubscribeAsync(NewSubscribeParams().WithName("dog"), func(reqid string, r *SubscribeOK, e error) {
+		if assert.NoError(t, e) {
+			texts <- r.Payload.Text
+		}
+	}, SubmitAsyncOptionSubscribe)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", subid)
+
+	for _, expected := range []string{"hi", "a", "b"} {
+		select {
+		case text := <-texts:
+			assert.Equal(t, expected, text)
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "message not received")
+		}
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, `{"id":"1","ack":1}`, <-acks)
+	}
+
+	// the subscription is removed at its end
+	wt := transport.(*wstransport)
+	pending := func() int {
+		wt.lock.RLock()
+		defer wt.lock.RUnlock()
+		return len(wt.pending)
+	}
+	for i := 0; i < 100 && pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, pending())
+}
+
+func TestClientGoAway(t *testing.T) {
+	var connections int32
+	goneAway := make(chan struct{})
+	reconnected := make(chan string, 1)
+	ts := newTestScriptedServer(t, func(ws *websocket.Conn, r *http.Request) {
+		if atomic.AddInt32(&connections, 1) == 1 {
+			assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"goaway":true,"delay":100}`)))
+			// the server closes the connection after the client stopped sending requests
+			<-goneAway
+			return
+		}
+		reconnected <- r.URL.Query().Get("x-tracking-id")
+		ws.ReadMessage() //nolint:errcheck
+	})
+	defer ts.Close()
+
+	start := time.Now()
+	transport := NewTransport("ws" + ts.URL[4:])
+	defer transport.Close()
+	client := New(transport, strfmt.Default)
+	wt := transport.(*wstransport)
+	goingAway := func() bool {
+		wt.writelock.Lock()
+		defer wt.writelock.Unlock()
+		return wt.goingAway
+	}
+	for i := 0; i < 100 && !goingAway(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err := client.Ping(nil)
+	assert.Equal(t, errGoingAway, err)
+	close(goneAway)
+
+	// the client reconnects with its tracking ID after the advised delay
+	select {
+	case trackingID := <-reconnected:
+		assert.Equal(t, testTrackingID, trackingID)
+		assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "not reconnected")
+	}
+	for i := 0; i < 100 && goingAway(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, goingAway())
+}
+
+// newTestScriptedServer returns the server which performs the handshake with each client and then runs the script
+func newTestScriptedServer(t *testing.T, script func(ws *websocket.Conn, r *http.Request)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ws.Close()
+		_, _, err = ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.NoError(t, ws.WriteJSON(&HandshakeResponse{Version: ProtocolVersion, TrackingID: testTrackingID}))
+		script(ws, r)
+	}))
+}
+
 // DO NOT EDIT BELOW
 // the code are copied from github.com/elakito/swagsock/examples/greeter-client/client and adjusted to avoid creating a cyclic dependency
 func New(transport runtime.ClientTransport, formats strfmt.Registry) *Client {
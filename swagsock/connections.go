@@ -0,0 +1,151 @@
+package swagsock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeWriteWait is the time allowed to write the close message of a forced disconnect
+const closeWriteWait = time.Second
+
+var errNoConnection = errors.New("no_connection")
+
+// connection is a client connection which counts the messages written to it
+type connection struct {
+	*websocket.Conn
+	trackingID string
+	request    *http.Request
+	principal  string
+	connected  time.Time
+	received   uint64
+	sent       uint64
+	handshaked int32
+	// serializes the writes to the connection
+	connlock *sync.Mutex
+	// request id -> call waiting for the client's response
+	calls map[string]*reverseCall
+	lock  sync.Mutex
+}
+
+func newConnection(conn *websocket.Conn, trackingID string, r *http.Request, principal string) *connection {
+	return &connection{Conn: conn, trackingID: trackingID, request: r, principal: principal, connected: time.Now(), connlock: &sync.Mutex{}}
+}
+
+func (c *connection) WriteMessage(messageType int, data []byte) error {
+	atomic.AddUint64(&c.sent, 1)
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+func (c *connection) WriteJSON(v interface{}) error {
+	atomic.AddUint64(&c.sent, 1)
+	return c.Conn.WriteJSON(v)
+}
+
+// info returns the snapshot of the connection without its subscriptions
+func (c *connection) info() *ConnectionInfo {
+	ci := &ConnectionInfo{TrackingID: c.trackingID, Connected: c.connected, Principal: c.principal,
+		Received: atomic.LoadUint64(&c.received), Sent: atomic.LoadUint64(&c.sent)}
+	if c.request != nil {
+		ci.RemoteAddr = c.request.RemoteAddr
+		ci.UserAgent = c.request.UserAgent()
+	}
+	return ci
+}
+
+// defaultPrincipal returns the user of the basic authentication of the websocket upgrade request
+func defaultPrincipal(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+func (ph *protocolHandler) Connections() []*ConnectionInfo {
+	ph.RLock()
+	conns := make([]*ConnectionInfo, 0, len(ph.connections))
+	for _, c := range ph.connections {
+		conns = append(conns, c.info())
+	}
+	ph.RUnlock()
+	for _, ci := range conns {
+		ci.Subscriptions = ph.mediator.Subscriptions(SubscriptionFilter{TrackingID: ci.TrackingID})
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].Connected.Equal(conns[j].Connected) {
+			return conns[i].TrackingID < conns[j].TrackingID
+		}
+		return conns[i].Connected.Before(conns[j].Connected)
+	})
+	return conns
+}
+
+func (ph *protocolHandler) Disconnect(trackingID string, code int, reason string) error {
+	c := ph.getConnection(trackingID)
+	if c == nil {
+		return errNoConnection
+	}
+	// the disconnected client's session is not resumable
+	ph.closeConnection(c, code, reason, false)
+	return nil
+}
+
+// closeConnection removes the connection and its subscriptions, which are parked instead if resumable, and closes it
+// with the code
+func (ph *protocolHandler) closeConnection(c *connection, code int, reason string, resumable bool) {
+	ph.disconnected(c.Conn, code, resumable)
+	if err := c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWriteWait)); err != nil {
+		ph.log.Printf("Failed to write the close message: %s", err.Error())
+	}
+	c.Close()
+}
+
+// resolveDuplicate returns the tracking ID of the new connection after applying the duplicate policy. Within the
+// resume window, the new connection replacing the other connection takes over its session, as the other connection
+// may be broken without the server having noticed it yet
+func (ph *protocolHandler) resolveDuplicate(trackingID string) string {
+	c := ph.getConnection(trackingID)
+	if c == nil {
+		return trackingID
+	}
+	switch ph.duplicates {
+	case DuplicateReplace:
+		if ph.resumeWindow > 0 {
+			// the session is parked so that the new connection resumes it
+			ph.log.Printf("taking over the session trackingID=%s", trackingID)
+			ph.closeConnection(c, CloseReplaced, "resumed", true)
+		} else {
+			ph.closeConnection(c, CloseReplaced, "replaced", false)
+		}
+		return trackingID
+	case DuplicateScope, DuplicateReject:
+		// the duplicate racing with another connection after the rejection check is also scoped
+		return fmt.Sprintf("%s~%d", trackingID, atomic.AddUint64(&ph.scopes, 1))
+	default:
+		return trackingID
+	}
+}
+
+// NewAdminHandler returns a read-only http.Handler which renders the connections of the protocol handler in JSON
+func NewAdminHandler(ph ProtocolHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ph.Connections()); err != nil {
+			logger := Logger(defaultLogger)
+			if p, ok := ph.(*protocolHandler); ok {
+				logger = p.log
+			}
+			logger.Printf("failed to write the connections: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package swagsock
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// defaultEvictAfter is the number of consecutive write failures after which a subscriber is evicted
+const defaultEvictAfter = 3
+
+// errNotDelivered indicates that the message is not meant for the subscriber, which is neither a delivery nor a failure
+var errNotDelivered = errors.New("not_delivered")
+
+// errDeferred indicates that the message is added to the outbox and its outcome is recorded when the outbox is sent
+var errDeferred = errors.New("deferred")
+
+// errThrottled indicates that the delivery policy holds the message back to deliver it later or to conflate it
+var errThrottled = errors.New("throttled")
+
+// errDropped indicates that the delivery policy drops the message exceeding the rate
+var errDropped = errors.New("dropped")
+
+// the kinds of the outgoing messages
+const (
+	// a delivery recorded in the subscription's statistics and written with a delivery id if acknowledged
+	outgoingDelivery = iota
+	// a redelivery, replayed or retained message written as is
+	outgoingPush
+	// the terminal message of the subscription
+	outgoingEnd
+)
+
+// outbox collects the messages decided under the mediator's lock to write them after the lock is released, so that a
+// slow subscriber does not hold up the subscriptions being added or removed
+type outbox struct {
+	messages []*outgoing
+}
+
+// outgoing is a message to be written to the subscriber's writer captured under the mediator's lock
+type outgoing struct {
+	r       *ReusableResponder
+	writer  http.ResponseWriter
+	headers map[string]interface{}
+	data    []byte
+	kind    int
+	// the outcome of the write, which is set by the sender that writes the message
+	err error
+}
+
+// post queues the message to the subscriber and adds it to the outbox. The messages of a subscriber are written in the
+// order they are posted
+func (out *outbox) post(o *outgoing) {
+	o.r.outlock.Lock()
+	o.r.outq = append(o.r.outq, o)
+	o.r.outlock.Unlock()
+	out.messages = append(out.messages, o)
+}
+
+// send writes the messages of the outbox and records the outcomes of the deliveries. The caller must not hold the
+// mediator's lock
+func (m *defaultResponseMediator) send(out *outbox, report *DeliveryReport) {
+	for _, o := range out.messages {
+		m.drain(o.r)
+		if o.kind == outgoingDelivery {
+			report.record(o.r, o.err)
+		}
+	}
+}
+
+// drain writes the messages queued to the subscriber, including those posted by other senders, which wait until
+// their messages are written
+func (m *defaultResponseMediator) drain(r *ReusableResponder) {
+	r.sendlock.Lock()
+	defer r.sendlock.Unlock()
+	for {
+		r.outlock.Lock()
+		queued := r.outq
+		r.outq = nil
+		r.outlock.Unlock()
+		if len(queued) == 0 {
+			return
+		}
+		for _, o := range queued {
+			o.err = m.writeOutgoing(o)
+		}
+	}
+}
+
+// writeOutgoing writes the message according to its kind
+func (m *defaultResponseMediator) writeOutgoing(o *outgoing) error {
+	switch o.kind {
+	case outgoingDelivery:
+		return m.writeNow(o.r, o.writer, o.headers, o.data)
+	case outgoingEnd:
+		_, err := o.writer.(headersWriter).writeWithHeaders(o.headers, nil)
+		if err != nil {
+			m.log.Printf("failed to write the terminal message: %s", err.Error())
+		}
+		return err
+	default:
+		_, err := o.r.writeWithHeadersTo(o.writer, o.headers, o.data)
+		if err != nil {
+			m.log.Printf("failed to write: %s", err.Error())
+		}
+		return err
+	}
+}
+
+// MediatorOptionEvictAfter sets the number of consecutive write failures after which a subscriber is evicted by Write
+// and WriteTopic. An evicted subscription is removed and its bye message is broadcast, whereas an evicted durable
+// subscription is kept offline to queue its messages. No subscribers are evicted when failures is 0
+func MediatorOptionEvictAfter(failures int) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.evictAfter = failures
+	}
+}
+
+// record adds the outcome of writing the message to the subscriber. The report may be nil when nobody is interested
+func (dr *DeliveryReport) record(r *ReusableResponder, err error) {
+	if dr == nil || err == errNotDelivered || err == errDeferred {
+		return
+	}
+	switch err {
+	case nil:
+		dr.Delivered++
+		return
+	case errThrottled:
+		dr.Throttled++
+		return
+	case errDropped:
+		dr.Dropped++
+		return
+	}
+	dr.Failed++
+	trackingID, rid := splitRequestKey(r.key)
+	dr.Errors = append(dr.Errors, &SubscriberError{Name: r.name, TrackingID: trackingID, SubscriptionID: rid, Err: err})
+}
+
+// evictFailed evicts the failed subscribers of the report whose consecutive write failures reached the limit
+func (m *defaultResponseMediator) evictFailed(report *DeliveryReport) {
+	if m.evictAfter <= 0 || report.Failed == 0 {
+		return
+	}
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	for _, se := range report.Errors {
+		key := buildRequestKey(se.TrackingID, se.SubscriptionID)
+		r, ok := m.responders[key]
+		if !ok || r.offline || atomic.LoadInt32(&r.failures) < int32(m.evictAfter) {
+			continue
+		}
+		m.log.Printf("evicting subscriber %s after %d write failures", key, atomic.LoadInt32(&r.failures))
+		m.evict(key, r)
+		report.Evicted++
+	}
+}
+
+// evict removes the subscriber whose connection is presumably gone or takes it offline if it is durable
+func (m *defaultResponseMediator) evict(key string, r *ReusableResponder) {
+	atomic.StoreInt32(&r.failures, 0)
+	if !r.durable {
+		m.unsubscribe(key, r)
+		return
+	}
+	r.offline = true
+	r.writer = nil
+	m.writeBye(r)
+	m.leave(r, m.presenceTopics(r))
+}
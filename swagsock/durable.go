@@ -0,0 +1,245 @@
+package swagsock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	durableFileSuffix  = ".json"
+	durableQueueSuffix = ".queue"
+)
+
+// NewFileSubscriptionStore returns a SubscriptionStore that keeps each durable subscription in a json file and its
+// queued messages in a queue file in the specified directory. At most limit messages are queued per subscription and
+// the oldest messages are dropped beyond this limit
+func NewFileSubscriptionStore(dir string, limit int) (SubscriptionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileSubscriptionStore{dir: dir, limit: limit, queued: make(map[string]int)}, nil
+}
+
+type fileSubscriptionStore struct {
+	dir   string
+	limit int
+	// queue file -> the number of the messages appended to it
+	queued map[string]int
+	sync.Mutex
+}
+
+// durableRecord is the content of the file that keeps a durable subscription
+type durableRecord struct {
+	Subscription *DurableSubscription `json:"subscription"`
+}
+
+func (s *fileSubscriptionStore) Save(sub *DurableSubscription) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.write(&durableRecord{Subscription: sub})
+}
+
+func (s *fileSubscriptionStore) Delete(trackingID string, requestID string) error {
+	s.Lock()
+	defer s.Unlock()
+	qpath := s.queuePath(trackingID, requestID)
+	delete(s.queued, qpath)
+	for _, path := range []string{s.path(trackingID, requestID), qpath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSubscriptionStore) LoadAll() ([]*DurableSubscription, error) {
+	s.Lock()
+	defer s.Unlock()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*DurableSubscription, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), durableFileSuffix) {
+			continue
+		}
+		rec, err := s.readFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil && rec.Subscription != nil {
+			subs = append(subs, rec.Subscription)
+		}
+	}
+	return subs, nil
+}
+
+// Enqueue appends the message to the queue file of the subscription. The queue file is compacted to the last limit
+// messages when it holds twice as many messages, so that the file is not rewritten for every message
+func (s *fileSubscriptionStore) Enqueue(trackingID string, requestID string, msg *QueuedMessage) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.limit <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(s.path(trackingID, requestID)); os.IsNotExist(err) {
+		// the subscription is no longer stored
+		return nil
+	} else if err != nil {
+		return err
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	qpath := s.queuePath(trackingID, requestID)
+	count, ok := s.queued[qpath]
+	if !ok {
+		queue, err := s.readQueue(qpath)
+		if err != nil {
+			return err
+		}
+		count = len(queue)
+	}
+	f, err := os.OpenFile(qpath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	count++
+	if count >= 2*s.limit {
+		queue, err := s.readQueue(qpath)
+		if err != nil {
+			return err
+		}
+		if err := s.writeQueue(qpath, s.last(queue)); err != nil {
+			return err
+		}
+		count = s.limit
+	}
+	s.queued[qpath] = count
+	return nil
+}
+
+func (s *fileSubscriptionStore) Dequeue(trackingID string, requestID string) ([]*QueuedMessage, error) {
+	s.Lock()
+	defer s.Unlock()
+	qpath := s.queuePath(trackingID, requestID)
+	queue, err := s.readQueue(qpath)
+	if err != nil || len(queue) == 0 {
+		return nil, err
+	}
+	delete(s.queued, qpath)
+	if err := os.Remove(qpath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.last(queue), nil
+}
+
+func (s *fileSubscriptionStore) path(trackingID string, requestID string) string {
+	// the request key consists of the client supplied values and must be escaped to be used as the file name
+	return filepath.Join(s.dir, escapeFileName(buildRequestKey(trackingID, requestID))+durableFileSuffix)
+}
+
+func (s *fileSubscriptionStore) queuePath(trackingID string, requestID string) string {
+	return filepath.Join(s.dir, escapeFileName(buildRequestKey(trackingID, requestID))+durableQueueSuffix)
+}
+
+// last returns the last limit messages of the queue
+func (s *fileSubscriptionStore) last(queue []*QueuedMessage) []*QueuedMessage {
+	if len(queue) > s.limit {
+		return queue[len(queue)-s.limit:]
+	}
+	return queue
+}
+
+// readQueue reads the messages of the queue file. A message partially written at the end of the file is skipped
+func (s *fileSubscriptionStore) readQueue(qpath string) ([]*QueuedMessage, error) {
+	f, err := os.Open(qpath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var queue []*QueuedMessage
+	dec := json.NewDecoder(f)
+	for {
+		var msg *QueuedMessage
+		if err := dec.Decode(&msg); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return queue, nil
+		} else if err != nil {
+			return nil, err
+		}
+		queue = append(queue, msg)
+	}
+}
+
+// writeQueue replaces the queue file with the messages
+func (s *fileSubscriptionStore) writeQueue(qpath string, queue []*QueuedMessage) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range queue {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(qpath+".tmp", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(qpath+".tmp", qpath)
+}
+
+func (s *fileSubscriptionStore) readFile(path string) (*durableRecord, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rec *durableRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (s *fileSubscriptionStore) write(rec *durableRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	path := s.path(rec.Subscription.TrackingID, rec.Subscription.RequestID)
+	// write to a temporary file first to not leave a partially written file behind
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// escapeFileName escapes the characters that are not safe to be used in a file name
+func escapeFileName(name string) string {
+	var sb strings.Builder
+	for _, c := range []byte(name) {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,68 @@
+package swagsock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSubscriptionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swagsock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileSubscriptionStore(dir, 2)
+	assert.NoError(t, err)
+	sub1 := &DurableSubscription{TrackingID: testTrackingID, RequestID: "1", Name: "naranja", Topic: "general", Topics: []string{"general"}, MediaType: "application/json"}
+	sub2 := &DurableSubscription{TrackingID: "../" + testTrackingID, RequestID: "2", Name: "manzana"}
+	assert.NoError(t, store.Save(sub1))
+	assert.NoError(t, store.Save(sub2))
+
+	subs, err := store.LoadAll()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*DurableSubscription{sub1, sub2}, subs)
+
+	for _, data := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Enqueue(testTrackingID, "1", &QueuedMessage{Headers: map[string]interface{}{"topic": "general"}, Data: []byte(data)}))
+	}
+	// no queue for an unknown subscription
+	assert.NoError(t, store.Enqueue(testTrackingID, "3", &QueuedMessage{Data: []byte("x")}))
+
+	queued, err := store.Dequeue(testTrackingID, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*QueuedMessage{
+		{Headers: map[string]interface{}{"topic": "general"}, Data: []byte("b")},
+		{Headers: map[string]interface{}{"topic": "general"}, Data: []byte("c")},
+	}, queued)
+	queued, err = store.Dequeue(testTrackingID, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, queued)
+
+	// the queue file is compacted to the last messages and its messages survive a restart
+	for _, data := range []string{"d", "e", "f", "g", "h"} {
+		assert.NoError(t, store.Enqueue(testTrackingID, "1", &QueuedMessage{Data: []byte(data)}))
+	}
+	lines, err := ioutil.ReadFile(store.(*fileSubscriptionStore).queuePath(testTrackingID, "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, bytes.Count(lines, []byte("\n")))
+	store, err = NewFileSubscriptionStore(dir, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Enqueue(testTrackingID, "1", &QueuedMessage{Data: []byte("i")}))
+	queued, err = store.Dequeue(testTrackingID, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*QueuedMessage{{Data: []byte("h")}, {Data: []byte("i")}}, queued)
+
+	assert.NoError(t, store.Delete(testTrackingID, "1"))
+	assert.NoError(t, store.Delete(testTrackingID, "3"))
+	subs, err = store.LoadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []*DurableSubscription{sub2}, subs)
+}
+
+func TestEscapeFileName(t *testing.T) {
+	assert.Equal(t, "b0cbb3b4-aaee%23_1", escapeFileName("b0cbb3b4-aaee#_1"))
+	assert.Equal(t, "%2E%2E%2Fx%0A", escapeFileName("../x\n"))
+}
@@ -0,0 +1,78 @@
+package swagsock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SubscribeOptionTTL sets the time-to-live of the subscription after which it is removed and its subscriber receives
+// the terminal message with code 410 and "end": true
+func SubscribeOptionTTL(ttl time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ttl = ttl
+	}
+}
+
+// SubscribeOptionIdleTimeout sets the duration after which the subscription that has received no messages is removed
+// and its subscriber receives the terminal message with code 410 and "end": true
+func SubscribeOptionIdleTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.idleTimeout = timeout
+	}
+}
+
+// startExpiry starts the timers of the subscription's time-to-live and idle timeout. The caller must hold the mediator's lock
+func (m *defaultResponseMediator) startExpiry(r *ReusableResponder) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.ttl > 0 {
+		r.ttlTimer = time.AfterFunc(r.ttl, func() {
+			m.expire(r, EndReasonTTL)
+		})
+	}
+	if r.idleTimeout > 0 {
+		r.idleTimer = time.AfterFunc(r.idleTimeout, func() {
+			m.expireIdle(r)
+		})
+	}
+}
+
+// expireIdle expires the subscription unless a message has been delivered during the idle timeout, in which case the
+// timer is rearmed for the rest of the timeout counted from the last delivery
+func (m *defaultResponseMediator) expireIdle(r *ReusableResponder) {
+	if ld := atomic.LoadInt64(&r.lastDelivery); ld != 0 {
+		if rest := r.idleTimeout - time.Since(time.Unix(0, ld)); rest > 0 {
+			r.lock.Lock()
+			if r.idleTimer != nil {
+				r.idleTimer.Reset(rest)
+			}
+			r.lock.Unlock()
+			return
+		}
+	}
+	m.expire(r, EndReasonIdle)
+}
+
+// expire removes the expired subscription after sending its terminal message
+func (m *defaultResponseMediator) expire(r *ReusableResponder, reason string) {
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	if m.responders[r.key] != r {
+		return
+	}
+	m.writeEnd(r, reason)
+	m.unsubscribe(r.key, r)
+}
+
+// stopExpiry stops the expiry timers of the removed subscription. The caller must hold the subscription lock
+func (r *ReusableResponder) stopExpiry() {
+	if r.ttlTimer != nil {
+		r.ttlTimer.Stop()
+		r.ttlTimer = nil
+	}
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
@@ -0,0 +1,158 @@
+package swagsock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// filterParam is the query parameter of the subscribe request that holds the filter expression
+	filterParam = "filter"
+	// filterConditionSeparator separates the conditions of a filter expression that must all hold
+	filterConditionSeparator = ","
+)
+
+var errInvalidFilter = errors.New("invalid_filter")
+
+// filterLevels ranks the severity levels compared by the ordering operators
+var filterLevels = map[string]int{"TRACE": 0, "DEBUG": 1, "INFO": 2, "WARN": 3, "WARNING": 3, "ERROR": 4, "FATAL": 5}
+
+// Filter is a predicate over the payload that decides whether the payload is delivered to the subscriber
+type Filter func(data []byte) bool
+
+// ParseFilter parses the declarative filter expression into a Filter for json payloads.
+//
+// The expression is a list of conditions separated by ',' that must all hold. Each condition compares a json field
+// with a value using one of the operators =, ==, !=, >, >=, <, and <=, as in severity>=WARN or source.host=db1.
+// Nested fields are addressed using '.'. Numbers are compared numerically. The ordering operators >, >=, <, and <=
+// compare strings only if both are severity levels, which are ordered as TRACE < DEBUG < INFO < WARN < ERROR < FATAL
+// ignoring the case, and do not hold for other strings or booleans. A condition on a missing field or a payload that
+// is not a json object does not hold.
+func ParseFilter(expr string) (Filter, error) {
+	var conds []*filterCondition
+	for _, cexpr := range strings.Split(expr, filterConditionSeparator) {
+		cond, err := parseFilterCondition(cexpr)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return func(data []byte) bool {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return false
+		}
+		for _, cond := range conds {
+			if !cond.holds(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// GetFilter returns the Filter for the filter expression given in the request's filter query parameter or nil if
+// no filter expression is given
+func GetFilter(req *http.Request) (Filter, error) {
+	expr := req.URL.Query().Get(filterParam)
+	if expr == "" {
+		return nil, nil
+	}
+	return ParseFilter(expr)
+}
+
+type filterCondition struct {
+	path  []string
+	op    string
+	value string
+}
+
+func parseFilterCondition(cexpr string) (*filterCondition, error) {
+	p := strings.IndexAny(cexpr, "=!<>")
+	if p <= 0 {
+		return nil, errInvalidFilter
+	}
+	op := cexpr[p : p+1]
+	if p+1 < len(cexpr) && cexpr[p+1] == '=' {
+		op = cexpr[p : p+2]
+	}
+	if op == "!" {
+		return nil, errInvalidFilter
+	}
+	field := strings.TrimSpace(cexpr[:p])
+	value := strings.TrimSpace(cexpr[p+len(op):])
+	if field == "" {
+		return nil, errInvalidFilter
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	if op == "==" {
+		op = "="
+	}
+	return &filterCondition{path: strings.Split(field, "."), op: op, value: value}, nil
+}
+
+func (c *filterCondition) holds(doc map[string]interface{}) bool {
+	var v interface{} = doc
+	for _, name := range c.path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if v, ok = obj[name]; !ok {
+			return false
+		}
+	}
+	var cmp int
+	switch fv := v.(type) {
+	case float64:
+		nv, err := strconv.ParseFloat(c.value, 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case fv < nv:
+			cmp = -1
+		case fv > nv:
+			cmp = 1
+		}
+	case string:
+		if c.op == "=" || c.op == "!=" {
+			return (fv == c.value) == (c.op == "=")
+		}
+		fl, ok := filterLevels[strings.ToUpper(fv)]
+		if !ok {
+			return false
+		}
+		vl, ok := filterLevels[strings.ToUpper(c.value)]
+		if !ok {
+			return false
+		}
+		cmp = fl - vl
+	case bool:
+		if c.op == "=" || c.op == "!=" {
+			return (strconv.FormatBool(fv) == c.value) == (c.op == "=")
+		}
+		return false
+	default:
+		return false
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
@@ -0,0 +1,70 @@
+package swagsock
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	payload := []byte(`{"severity":"WARN","code":503,"retry":true,"source":{"host":"db1"}}`)
+	for expr, expected := range map[string]bool{
+		"severity=WARN":             true,
+		"severity==WARN":            true,
+		`severity="WARN"`:           true,
+		"severity!=WARN":            false,
+		"severity>=WARN":            true,
+		"severity>INFO":             true,
+		"severity>=warn":            true,
+		"severity<ERROR":            true,
+		"severity>WARN":             false,
+		"severity>=NOTICE":          false,
+		"retry>false":               false,
+		"code>=500":                 true,
+		"code<500":                  false,
+		"code <= 503":               true,
+		"retry=true":                true,
+		"source.host=db1":           true,
+		"source.host=db2":           false,
+		"source.port=5432":          false,
+		"severity>=WARN,code>=500":  true,
+		"severity>=WARN,code>=600":  false,
+		"code=five hundred and one": false,
+	} {
+		filter, err := ParseFilter(expr)
+		assert.NoError(t, err, "expression %s", expr)
+		assert.Equal(t, expected, filter(payload), "expression %s", expr)
+	}
+
+	filter, err := ParseFilter("severity>=WARN")
+	assert.NoError(t, err)
+	assert.True(t, filter([]byte(`{"severity":"ERROR"}`)))
+	assert.True(t, filter([]byte(`{"severity":"FATAL"}`)))
+	assert.False(t, filter([]byte(`{"severity":"INFO"}`)))
+	assert.False(t, filter([]byte(`{"severity":"unknown"}`)))
+	filter, err = ParseFilter("severity>INFO")
+	assert.NoError(t, err)
+	assert.False(t, filter([]byte(`{"severity":"DEBUG"}`)))
+	assert.True(t, filter([]byte(`{"severity":"ERROR"}`)))
+	assert.False(t, filter([]byte(`"WARN"`)))
+	assert.False(t, filter([]byte(`not json`)))
+
+	for _, expr := range []string{"", "severity", "=WARN", "severity!WARN", "severity>=WARN,"} {
+		_, err := ParseFilter(expr)
+		assert.Error(t, err, "expression %s", expr)
+	}
+}
+
+func TestGetFilter(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/v1/subscribe/dog?filter=code%3E%3D500", nil) //nolint:errcheck
+	filter, err := GetFilter(req)
+	assert.NoError(t, err)
+	assert.True(t, filter([]byte(`{"code":503}`)))
+	assert.False(t, filter([]byte(`{"code":200}`)))
+
+	req, _ = http.NewRequest("GET", "/v1/subscribe/dog", nil) //nolint:errcheck
+	filter, err = GetFilter(req)
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+}
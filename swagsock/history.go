@@ -0,0 +1,71 @@
+package swagsock
+
+import (
+	"sync"
+)
+
+// nameStream returns the history stream of the messages written to the specified name
+func nameStream(name string) string {
+	return "name:" + name
+}
+
+// topicStream returns the history stream of the messages written to the specified topic
+func topicStream(topic string) string {
+	return "topic:" + topic
+}
+
+// NewMemoryHistoryStore returns a HistoryStore that keeps the last size messages of each stream in memory. No messages
+// are kept if size is not positive
+func NewMemoryHistoryStore(size int) HistoryStore {
+	if size < 0 {
+		size = 0
+	}
+	return &memoryHistoryStore{size: size, streams: make(map[string]*historyRing)}
+}
+
+type memoryHistoryStore struct {
+	size    int
+	streams map[string]*historyRing
+	sync.Mutex
+}
+
+// historyRing is a ring buffer of the last messages of a stream
+type historyRing struct {
+	seq      uint64
+	messages []*HistoryMessage
+	next     int
+}
+
+func (s *memoryHistoryStore) Append(stream string, data []byte) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	ring, ok := s.streams[stream]
+	if !ok {
+		ring = &historyRing{messages: make([]*HistoryMessage, 0, s.size)}
+		s.streams[stream] = ring
+	}
+	ring.seq++
+	msg := &HistoryMessage{Seq: ring.seq, Data: data}
+	if len(ring.messages) < s.size {
+		ring.messages = append(ring.messages, msg)
+	} else if s.size > 0 {
+		ring.messages[ring.next] = msg
+		ring.next = (ring.next + 1) % s.size
+	}
+	return ring.seq, nil
+}
+
+func (s *memoryHistoryStore) Since(stream string, seq uint64) ([]*HistoryMessage, error) {
+	s.Lock()
+	defer s.Unlock()
+	messages := make([]*HistoryMessage, 0)
+	if ring, ok := s.streams[stream]; ok {
+		for i := range ring.messages {
+			msg := ring.messages[(ring.next+i)%len(ring.messages)]
+			if msg.Seq > seq {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,49 @@
+package swagsock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryHistoryStore(t *testing.T) {
+	store := NewMemoryHistoryStore(3)
+	for i, data := range []string{"a", "b", "c", "d", "e"} {
+		seq, err := store.Append(topicStream("general"), []byte(data))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i+1), seq)
+	}
+	seq, err := store.Append(nameStream("general"), []byte("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+
+	messages, err := store.Since(topicStream("general"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HistoryMessage{{Seq: 3, Data: []byte("c")}, {Seq: 4, Data: []byte("d")}, {Seq: 5, Data: []byte("e")}}, messages)
+
+	messages, err = store.Since(topicStream("general"), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HistoryMessage{{Seq: 5, Data: []byte("e")}}, messages)
+
+	messages, err = store.Since(topicStream("general"), 5)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = store.Since(nameStream("general"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HistoryMessage{{Seq: 1, Data: []byte("x")}}, messages)
+
+	messages, err = store.Since(topicStream("private"), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestMemoryHistoryStoreNegativeSize(t *testing.T) {
+	store := NewMemoryHistoryStore(-1)
+	seq, err := store.Append(topicStream("general"), []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	messages, err := store.Since(topicStream("general"), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
@@ -0,0 +1,52 @@
+package swagsock
+
+// keySet is a set of subscription keys
+type keySet map[string]struct{}
+
+// keys returns the copy of the keys to iterate while the set is modified
+func (ks keySet) keys() []string {
+	keys := make([]string, 0, len(ks))
+	for key := range ks {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// addResponder registers the subscription in the responders and their tracking ID and name indices
+func (m *defaultResponseMediator) addResponder(key string, r *ReusableResponder) {
+	if old, ok := m.responders[key]; ok {
+		m.removeResponder(key, old)
+		if !r.durable {
+			// the subscription no longer durable leaves no stored subscription and queue behind
+			m.deleteDurable(old)
+		}
+	}
+	m.responders[key] = r
+	addIndex(m.byTracking, trackingIDOf(key), key)
+	addIndex(m.byName, r.name, key)
+}
+
+// removeResponder unregisters the subscription from the responders and their indices
+func (m *defaultResponseMediator) removeResponder(key string, r *ReusableResponder) {
+	delete(m.responders, key)
+	removeIndex(m.byTracking, trackingIDOf(key), key)
+	removeIndex(m.byName, r.name, key)
+}
+
+func addIndex(index map[string]keySet, id string, key string) {
+	keys, ok := index[id]
+	if !ok {
+		keys = make(keySet)
+		index[id] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func removeIndex(index map[string]keySet, id string, key string) {
+	if keys, ok := index[id]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(index, id)
+		}
+	}
+}
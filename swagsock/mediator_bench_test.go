@@ -0,0 +1,154 @@
+package swagsock
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	benchConnections = 10000
+	benchTopics      = 1000
+)
+
+type benchWriter struct {
+}
+
+func (w *benchWriter) Header() http.Header {
+	return nil
+}
+func (w *benchWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+func (w *benchWriter) WriteHeader(statusCode int) {
+}
+
+// benchSlowWriter is a benchWriter of a subscriber on a slow network
+type benchSlowWriter struct {
+	benchWriter
+}
+
+func (w *benchSlowWriter) Write(b []byte) (int, error) {
+	time.Sleep(50 * time.Microsecond)
+	return len(b), nil
+}
+
+// newBenchMediator returns a mediator with one name subscription and one topic subscription for each connection
+func newBenchMediator() ResponseMediator {
+	mediator := NewDefaultResponseMediator()
+	for i := 0; i < benchConnections; i++ {
+		benchSubscribe(mediator, i)
+	}
+	return mediator
+}
+
+func benchSubscribe(mediator ResponseMediator, i int) {
+	trackingID := fmt.Sprintf("conn-%d", i)
+	rr := mediator.Subscribe(buildRequestKey(trackingID, "1"), fmt.Sprintf("user-%d", i), &testOK{}, nil, nil)
+	rr.WriteResponse(&benchWriter{}, nil)
+	rr = mediator.SubscribeTopic(buildRequestKey(trackingID, "2"), fmt.Sprintf("room/%d", i%benchTopics), fmt.Sprintf("user-%d", i), &testOK{}, nil, nil)
+	rr.WriteResponse(&benchWriter{}, nil)
+}
+
+func BenchmarkUnsubscribeAll(b *testing.B) {
+	mediator := newBenchMediator()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := n % benchConnections
+		mediator.UnsubscribeAll(fmt.Sprintf("conn-%d", i))
+		b.StopTimer()
+		benchSubscribe(mediator, i)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSubscribeUnsubscribe(b *testing.B) {
+	mediator := newBenchMediator()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		key := buildRequestKey("bench", "1")
+		rr := mediator.SubscribeTopic(key, fmt.Sprintf("room/%d", n%benchTopics), "bench", &testOK{}, nil, nil)
+		rr.WriteResponse(&benchWriter{}, nil)
+		mediator.Unsubscribe(key, "1")
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	mediator := newBenchMediator()
+	data := []byte("hello")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		mediator.Write(fmt.Sprintf("user-%d", n%benchConnections), data) //nolint:errcheck
+	}
+}
+
+func BenchmarkWriteTopic(b *testing.B) {
+	mediator := newBenchMediator()
+	data := []byte("hello")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		mediator.WriteTopic(fmt.Sprintf("room/%d", n%benchTopics), data) //nolint:errcheck
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	mediator := newBenchMediator()
+	data := []byte("hello")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		mediator.WriteTo(fmt.Sprintf("conn-%d", n%benchConnections), data) //nolint:errcheck
+	}
+}
+
+func BenchmarkWriteTopicParallel(b *testing.B) {
+	mediator := newBenchMediator()
+	data := []byte("hello")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var n int
+		for pb.Next() {
+			mediator.WriteTopic(fmt.Sprintf("room/%d", n%benchTopics), data) //nolint:errcheck
+			n++
+		}
+	})
+}
+
+// BenchmarkUnsubscribeAllDuringSlowWriteTopic measures the disconnects while the topics are written concurrently to
+// the slow subscribers, whose writes must not hold up the disconnects
+func BenchmarkUnsubscribeAllDuringSlowWriteTopic(b *testing.B) {
+	mediator := newBenchMediator()
+	for i := 0; i < benchTopics; i++ {
+		rr := mediator.SubscribeTopic(buildRequestKey(fmt.Sprintf("slow-%d", i), "1"), fmt.Sprintf("room/%d", i), "slow", &testOK{}, nil, nil)
+		rr.WriteResponse(&benchSlowWriter{}, nil)
+	}
+	data := []byte("hello")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for ; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				mediator.WriteTopic(fmt.Sprintf("room/%d", n%benchTopics), data) //nolint:errcheck
+			}
+		}(w)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := n % benchConnections
+		mediator.UnsubscribeAll(fmt.Sprintf("conn-%d", i))
+		b.StopTimer()
+		benchSubscribe(mediator, i)
+		b.StartTimer()
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,128 @@
+package swagsock
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+)
+
+// MediatorOptionProducers sets the producers per media type which WriteObject and WriteTopicObject use for the
+// subscribers whose negotiated producer is unknown, such as the restored durable subscriptions. The producers for
+// json, xml, text, and octet-stream are used by default
+func MediatorOptionProducers(producers map[string]runtime.Producer) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.producers = producers
+	}
+}
+
+func defaultProducers() map[string]runtime.Producer {
+	return map[string]runtime.Producer{
+		runtime.JSONMime:    runtime.JSONProducer(),
+		runtime.XMLMime:     runtime.XMLProducer(),
+		runtime.TextMime:    runtime.TextProducer(),
+		runtime.DefaultMime: runtime.ByteStreamProducer(),
+	}
+}
+
+// objectEncoder encodes the object once per media type for the subscribers of a single write
+type objectEncoder struct {
+	v       interface{}
+	encoded map[string][]byte
+}
+
+func newObjectEncoder(v interface{}) *objectEncoder {
+	return &objectEncoder{v: v, encoded: make(map[string][]byte)}
+}
+
+// encode encodes the object using the subscriber's producer and returns the data and its media type
+func (m *defaultResponseMediator) encode(enc *objectEncoder, r *ReusableResponder) ([]byte, string, error) {
+	mediaType := r.mediaType
+	if mediaType == "" {
+		mediaType = runtime.JSONMime
+	}
+	if data, ok := enc.encoded[mediaType]; ok {
+		return data, mediaType, nil
+	}
+	producer := r.producer
+	if producer == nil {
+		// ignore the media type parameters such as charset
+		producer = m.producers[strings.TrimSpace(strings.Split(mediaType, ";")[0])]
+	}
+	if producer == nil {
+		return nil, mediaType, fmt.Errorf("no producer: %q", mediaType)
+	}
+	var buf bytes.Buffer
+	if err := producer.Produce(&buf, enc.v); err != nil {
+		return nil, mediaType, err
+	}
+	enc.encoded[mediaType] = buf.Bytes()
+	return enc.encoded[mediaType], mediaType, nil
+}
+
+// deliverObject encodes the object for the subscriber and delivers it with the envelope's type set to its media type
+func (m *defaultResponseMediator) deliverObject(r *ReusableResponder, headers map[string]interface{}, enc *objectEncoder, report *DeliveryReport, out *outbox) {
+	data, mediaType, err := m.encode(enc, r)
+	if err != nil {
+		report.record(r, err)
+		return
+	}
+	oheaders := make(map[string]interface{}, len(headers)+1)
+	for k, v := range headers {
+		oheaders[k] = v
+	}
+	oheaders["type"] = mediaType
+	report.record(r, m.deliver(r, oheaders, data, out))
+}
+
+func (m *defaultResponseMediator) WriteObject(name string, v interface{}) (*DeliveryReport, error) {
+	if err := m.authorize("", ActionPublish, "", name); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	enc := newObjectEncoder(v)
+	out := &outbox{}
+	m.RLock()
+	if name == "*" {
+		for _, r := range m.responders {
+			m.deliverObject(r, nil, enc, report, out)
+		}
+	} else {
+		for key := range m.byName[name] {
+			m.deliverObject(m.responders[key], nil, enc, report, out)
+		}
+	}
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
+
+func (m *defaultResponseMediator) WriteTopicObject(topic string, v interface{}) (*DeliveryReport, error) {
+	return m.WriteTopicObjectFrom("", topic, v)
+}
+
+func (m *defaultResponseMediator) WriteTopicObjectFrom(key string, topic string, v interface{}) (*DeliveryReport, error) {
+	var headers map[string]interface{}
+	if topic != "*" {
+		if err := validateTopicName(topic); err != nil {
+			return nil, err
+		}
+		headers = map[string]interface{}{"topic": topic}
+	}
+	if err := m.authorize(key, ActionPublish, topic, ""); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	enc := newObjectEncoder(v)
+	out := &outbox{}
+	m.RLock()
+	m.forEachTopicSubscriber(topic, func(s string) {
+		m.deliverObject(m.responders[s], headers, enc, report, out)
+	})
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
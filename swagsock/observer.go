@@ -0,0 +1,68 @@
+package swagsock
+
+// MediatorOptionObserver registers the observer of the subscription lifecycle. Several observers may be registered
+func MediatorOptionObserver(observer Observer) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.observers = append(m.observers, observer)
+	}
+}
+
+// notify queues the notification of the observers which is sent by flush after the mediator lock is released. The
+// caller must hold the mediator's exclusive lock
+func (m *defaultResponseMediator) notify(n func(o Observer)) {
+	if len(m.observers) > 0 {
+		m.notifications = append(m.notifications, n)
+	}
+}
+
+// flush writes the messages posted to the subscribers and sends the queued notifications to the observers. The
+// notifications are sent by one flush at a time so that they reach the observers in the order of the changes, and
+// those queued while another flush is sending are left to it. The caller must not hold the mediator lock
+func (m *defaultResponseMediator) flush() {
+	m.Lock()
+	pending := m.pending
+	m.pending = outbox{}
+	dispatching := m.dispatching
+	m.dispatching = true
+	m.Unlock()
+	m.send(&pending, nil)
+	if dispatching {
+		return
+	}
+	for {
+		m.Lock()
+		notifications := m.notifications
+		m.notifications = nil
+		if len(notifications) == 0 {
+			m.dispatching = false
+			m.Unlock()
+			return
+		}
+		m.Unlock()
+		for _, n := range notifications {
+			for _, o := range m.observers {
+				n(o)
+			}
+		}
+	}
+}
+
+// notifySubscribe queues the OnSubscribe notification of the subscription
+func (m *defaultResponseMediator) notifySubscribe(key string, r *ReusableResponder) {
+	if len(m.observers) > 0 {
+		sub := m.subscriptionInfo(key, r)
+		m.notify(func(o Observer) {
+			o.OnSubscribe(sub)
+		})
+	}
+}
+
+// notifyUnsubscribe queues the OnUnsubscribe notification of the subscription before it is removed
+func (m *defaultResponseMediator) notifyUnsubscribe(key string, r *ReusableResponder) {
+	if len(m.observers) > 0 {
+		sub := m.subscriptionInfo(key, r)
+		m.notify(func(o Observer) {
+			o.OnUnsubscribe(sub)
+		})
+	}
+}
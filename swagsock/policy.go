@@ -0,0 +1,223 @@
+package swagsock
+
+import (
+	"time"
+)
+
+const (
+	deliverAll = iota
+	deliverConflated
+	deliverRateLimited
+)
+
+// DeliveryPolicy controls how the messages of a topic are pushed to a subscriber
+type DeliveryPolicy struct {
+	mode      int
+	window    time.Duration
+	rate      float64
+	burst     int
+	queueSize int
+}
+
+// DeliveryPolicyAll delivers every message immediately, which is the default
+func DeliveryPolicyAll() *DeliveryPolicy {
+	return &DeliveryPolicy{mode: deliverAll}
+}
+
+// DeliveryPolicyConflate delivers at most one message per window. The first message is delivered immediately and the
+// messages written during the rest of the window are conflated to the latest one, which is delivered at the window's end
+func DeliveryPolicyConflate(window time.Duration) *DeliveryPolicy {
+	return &DeliveryPolicy{mode: deliverConflated, window: window}
+}
+
+// DeliveryPolicyRateLimit delivers the messages at the rate of messages per second allowing bursts of burst messages.
+// The messages exceeding the rate are dropped if queueSize is 0, otherwise they are queued and delivered as the rate
+// permits. When the queue is full, its oldest message is discarded. A rate of 0 or less never permits more than the
+// burst, so the messages exceeding it are dropped instead of being queued forever
+func DeliveryPolicyRateLimit(rate float64, burst int, queueSize int) *DeliveryPolicy {
+	if burst < 1 {
+		burst = 1
+	}
+	if rate <= 0 {
+		rate = 0
+		queueSize = 0
+	}
+	return &DeliveryPolicy{mode: deliverRateLimited, rate: rate, burst: burst, queueSize: queueSize}
+}
+
+// topicPolicy is the delivery policy of the topics matching the topic filter
+type topicPolicy struct {
+	filter string
+	policy *DeliveryPolicy
+}
+
+// MediatorOptionTopicPolicy sets the delivery policy of the topics matching the topic filter. The policy of the first
+// matching filter applies unless the subscription has its own policy set by SubscribeOptionPolicy
+func MediatorOptionTopicPolicy(filter string, policy *DeliveryPolicy) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.policies = append(m.policies, &topicPolicy{filter: filter, policy: policy})
+	}
+}
+
+// policyOf returns the delivery policy of the message for the subscriber or nil if every message is delivered
+func (m *defaultResponseMediator) policyOf(r *ReusableResponder, headers map[string]interface{}) *DeliveryPolicy {
+	policy := r.policy
+	if policy == nil {
+		if topic, ok := headers["topic"].(string); ok {
+			for _, tp := range m.policies {
+				if matchTopicFilter(tp.filter, topic) {
+					policy = tp.policy
+					break
+				}
+			}
+		}
+	}
+	if policy == nil || policy.mode == deliverAll {
+		return nil
+	}
+	return policy
+}
+
+// throttledMessage is a message held back by the delivery policy
+type throttledMessage struct {
+	headers map[string]interface{}
+	data    []byte
+}
+
+// throttle keeps the state of the delivery policy of a subscriber for one topic
+type throttle struct {
+	policy *DeliveryPolicy
+	// conflation
+	sent   time.Time
+	latest *throttledMessage
+	// rate limiting
+	tokens  float64
+	refill  time.Time
+	pending []*throttledMessage
+	timer   *time.Timer
+}
+
+// throttle delivers the message to the subscriber according to the delivery policy. The caller must hold the mediator's lock
+func (m *defaultResponseMediator) throttle(r *ReusableResponder, policy *DeliveryPolicy, headers map[string]interface{}, data []byte, out *outbox) error {
+	topic, _ := headers["topic"].(string)
+	r.lock.Lock()
+	if r.throttles == nil {
+		r.throttles = make(map[string]*throttle)
+	}
+	t, ok := r.throttles[topic]
+	if !ok || t.policy != policy {
+		t = &throttle{policy: policy, tokens: float64(policy.burst), refill: time.Now()}
+		r.throttles[topic] = t
+	}
+	now := time.Now()
+	msg := &throttledMessage{headers: headers, data: data}
+	var send bool
+	switch policy.mode {
+	case deliverConflated:
+		if t.timer == nil && now.Sub(t.sent) >= policy.window {
+			t.sent = now
+			send = true
+		} else {
+			t.latest = msg
+			if t.timer == nil {
+				t.timer = time.AfterFunc(t.sent.Add(policy.window).Sub(now), func() {
+					m.releaseThrottled(r, t)
+				})
+			}
+		}
+	case deliverRateLimited:
+		t.fill(now)
+		if len(t.pending) == 0 && t.tokens >= 1 {
+			t.tokens--
+			send = true
+		} else if policy.queueSize == 0 {
+			r.lock.Unlock()
+			return errDropped
+		} else {
+			if len(t.pending) >= policy.queueSize {
+				t.pending = t.pending[1:]
+			}
+			t.pending = append(t.pending, msg)
+			if t.timer == nil {
+				t.timer = time.AfterFunc(t.wait(), func() {
+					m.releaseThrottled(r, t)
+				})
+			}
+		}
+	}
+	r.lock.Unlock()
+	if send {
+		return m.writeLater(r, headers, data, out)
+	}
+	return errThrottled
+}
+
+// fill adds the tokens accumulated since the last refill
+func (t *throttle) fill(now time.Time) {
+	t.tokens += now.Sub(t.refill).Seconds() * t.policy.rate
+	if t.tokens > float64(t.policy.burst) {
+		t.tokens = float64(t.policy.burst)
+	}
+	t.refill = now
+}
+
+// wait returns the duration until the next token is available
+func (t *throttle) wait() time.Duration {
+	if t.tokens >= 1 || t.policy.rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - t.tokens) / t.policy.rate * float64(time.Second))
+}
+
+// releaseThrottled delivers the messages held back by the throttle when its timer fires
+func (m *defaultResponseMediator) releaseThrottled(r *ReusableResponder, t *throttle) {
+	out := &outbox{}
+	defer m.send(out, nil)
+	m.RLock()
+	defer m.RUnlock()
+	if m.responders[r.key] != r {
+		return
+	}
+	var msgs []*throttledMessage
+	r.lock.Lock()
+	now := time.Now()
+	t.timer = nil
+	switch t.policy.mode {
+	case deliverConflated:
+		if t.latest != nil {
+			msgs = append(msgs, t.latest)
+			t.latest = nil
+			t.sent = now
+		}
+	case deliverRateLimited:
+		t.fill(now)
+		for len(t.pending) > 0 && t.tokens >= 1 {
+			msgs = append(msgs, t.pending[0])
+			t.pending = t.pending[1:]
+			t.tokens--
+		}
+		if len(t.pending) > 0 {
+			t.timer = time.AfterFunc(t.wait(), func() {
+				m.releaseThrottled(r, t)
+			})
+		}
+	}
+	r.lock.Unlock()
+	for _, msg := range msgs {
+		if r.offline {
+			m.enqueue(r, msg.headers, msg.data)
+		} else if r.writer != nil {
+			m.writeLater(r, msg.headers, msg.data, out) //nolint:errcheck
+		}
+	}
+}
+
+// stopThrottles discards the messages held back by the delivery policies. The caller must hold the subscription lock
+func (r *ReusableResponder) stopThrottles() {
+	for _, t := range r.throttles {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+	}
+	r.throttles = nil
+}
@@ -0,0 +1,146 @@
+package swagsock
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	// PresenceJoin is the type of the presence event raised when a subscriber joins a name or topic
+	PresenceJoin = "join"
+	// PresenceLeave is the type of the presence event raised when a subscriber leaves a name or topic
+	PresenceLeave = "leave"
+)
+
+// MediatorOptionPresence sets the encoder of the presence events which are broadcast to the subscribers of the topic
+// (or to the subscribers of the name for the subscriptions without topics) the event refers to. No presence events are
+// broadcast unless this option is set
+func MediatorOptionPresence(encoder PresenceEncoder) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.presence = encoder
+	}
+}
+
+// MediatorOptionScopedGreetings scopes the hello and bye messages of the topic subscriptions to the subscribers of the
+// topics of the subscription, as the presence events. Without this option, they are broadcast to all the topic subscribers
+// and the bye messages of the subscriptions removed by UnsubscribeAll to all the subscribers. The hello and bye messages
+// of the subscriptions without topics are always broadcast to all the subscribers
+func MediatorOptionScopedGreetings() MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.scopedGreetings = true
+	}
+}
+
+// EncodePresenceJSON is the PresenceEncoder that encodes the presence event in json
+func EncodePresenceJSON(event *PresenceEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// join marks the subscription present and raises the join events for its topics or its name
+func (m *defaultResponseMediator) join(r *ReusableResponder) {
+	if !r.joined.IsZero() {
+		return
+	}
+	r.joined = time.Now()
+	m.announce(r, PresenceJoin, m.presenceTopics(r))
+}
+
+// leave marks the subscription absent and raises the leave events for the specified topics or its name
+func (m *defaultResponseMediator) leave(r *ReusableResponder, topics []string) {
+	if r.joined.IsZero() {
+		return
+	}
+	r.joined = time.Time{}
+	m.announce(r, PresenceLeave, topics)
+}
+
+// presenceTopics returns the sorted topics of the subscription or the empty topic that denotes its name
+func (m *defaultResponseMediator) presenceTopics(r *ReusableResponder) []string {
+	topics := make([]string, 0, len(m.substopics[r.key]))
+	for topic := range m.substopics[r.key] {
+		topics = append(topics, topic)
+	}
+	if len(topics) == 0 && r.topic == "" {
+		return []string{""}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// announce broadcasts the presence events of the specified type for the topics of the subscription unless the
+// application's encoder suppresses them. The caller must hold the mediator's exclusive lock
+func (m *defaultResponseMediator) announce(r *ReusableResponder, etype string, topics []string) {
+	if m.presence == nil {
+		return
+	}
+	trackingID, _ := splitRequestKey(r.key)
+	now := time.Now()
+	for _, topic := range topics {
+		event := &PresenceEvent{Type: etype, Name: r.name, Topic: topic, TrackingID: trackingID, Timestamp: now, Metadata: r.metadata}
+		data, err := m.presence(event)
+		if err != nil {
+			m.log.Printf("failed to encode the presence event: %s", err.Error())
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		var headers map[string]interface{}
+		if topic != "" {
+			headers = map[string]interface{}{"topic": topic}
+		}
+		m.forEachPresenceSubscriber(r.name, topic, func(key string) {
+			m.deliverPolicy(m.responders[key], nil, headers, data, nil) //nolint:errcheck
+		})
+	}
+}
+
+// forEachPresenceSubscriber invokes fn for each subscription in the scope of the topic's presence. This scope consists of
+// the subscriptions whose topic filters match the topic or, for a topic filter, the subscriptions of the same topic filter.
+// An empty topic denotes the subscriptions without topics of the name, or of all names if name is empty.
+func (m *defaultResponseMediator) forEachPresenceSubscriber(name string, topic string, fn func(key string)) {
+	switch {
+	case topic == "" && name != "":
+		for key := range m.byName[name] {
+			if _, ok := m.substopics[key]; !ok && m.responders[key].topic == "" {
+				fn(key)
+			}
+		}
+	case topic == "":
+		for key, r := range m.responders {
+			if _, ok := m.substopics[key]; !ok && r.topic == "" {
+				fn(key)
+			}
+		}
+	case IsTopicFilter(topic):
+		for key, topics := range m.substopics {
+			if _, ok := topics[topic]; ok {
+				fn(key)
+			}
+		}
+	default:
+		m.forEachTopicSubscriber(topic, fn)
+	}
+}
+
+func (m *defaultResponseMediator) Presence(topic string) []*PresenceMember {
+	members := make([]*PresenceMember, 0)
+	m.RLock()
+	defer m.RUnlock()
+	m.forEachPresenceSubscriber("", topic, func(key string) {
+		r := m.responders[key]
+		if r.joined.IsZero() {
+			return
+		}
+		trackingID, _ := splitRequestKey(key)
+		members = append(members, &PresenceMember{Name: r.name, TrackingID: trackingID, Joined: r.joined, Metadata: r.metadata})
+	})
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Joined.Equal(members[j].Joined) {
+			return members[i].Name < members[j].Name
+		}
+		return members[i].Joined.Before(members[j].Joined)
+	})
+	return members
+}
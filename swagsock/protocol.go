@@ -10,15 +10,17 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-openapi/runtime"
 	"github.com/go-openapi/runtime/middleware"
 
 	"github.com/gorilla/websocket"
-	"github.com/satori/go.uuid"
 )
 
 const (
@@ -35,6 +37,7 @@ var (
 
 	//Revisit defining known errors
 	errVersionMismatch = errors.New("version_mismatch")
+	errNoSubscription  = errors.New("no_subscription")
 )
 
 var websocketUpgrader = websocket.Upgrader{
@@ -95,17 +98,50 @@ func NewConfig() *Config {
 
 // CreateProtocolHandler creates a new ProtocolHandler with the specified codec. If codec is nil, the defaultCodec is used
 func CreateProtocolHandler(conf *Config) ProtocolHandler {
-	return &protocolHandler{
-		codec: conf.Codec, mediator: conf.ResponseMediator, heartbeat: conf.Heartbeat, log: conf.Log, connections: make(map[*websocket.Conn]string), continued: make(map[string]io.WriteCloser)}
+	ph := &protocolHandler{
+		codec: conf.Codec, mediator: conf.ResponseMediator, heartbeat: conf.Heartbeat, log: conf.Log, connections: make(map[*websocket.Conn]*connection), continued: make(map[string]io.WriteCloser),
+		tracking: make(map[string]*connection), principal: conf.Principal, resumeWindow: conf.ResumeWindow, parked: make(map[string]*time.Timer),
+		duplicates: conf.DuplicateTrackingID, generate: conf.TrackingIDGenerator, issuer: conf.TrackingIDIssuer, goAwayDelay: conf.GoAwayDelay, admission: newAdmission(conf)}
+	if ph.generate == nil {
+		ph.generate = defaultTrackingIDGenerator
+	}
+	if ph.principal == nil {
+		ph.principal = defaultPrincipal
+	}
+	if ta, ok := conf.ResponseMediator.(topicAuthorizable); ok && conf.Authorizer != nil {
+		ta.setAuthorizer(conf.Authorizer, ph.getRequest)
+	}
+	if ml, ok := conf.ResponseMediator.(mediatorLoggable); ok && conf.Log != nil {
+		ml.setLogger(conf.Log)
+	}
+	return ph
 }
 
 type protocolHandler struct {
 	codec       Codec
-	connections map[*websocket.Conn]string
+	connections map[*websocket.Conn]*connection
 	mediator    ResponseMediator
 	continued   map[string]io.WriteCloser // temporary
 	heartbeat   int
 	log         Logger
+	// trackingid -> connection
+	tracking  map[string]*connection
+	principal func(r *http.Request) string
+	// trackingid -> expiry of the parked session
+	parked       map[string]*time.Timer
+	resumeWindow time.Duration
+	duplicates   DuplicatePolicy
+	generate     func() string
+	issuer       TrackingIDIssuer
+	// the sequence number of the session scopes of the duplicate tracking IDs
+	scopes uint64
+	// the sequence number of the calls to the clients
+	nextCall uint64
+	// the number of the requests being served
+	inflight    int64
+	shutdown    int32
+	goAwayDelay time.Duration
+	admission   *admission
 	sync.RWMutex
 }
 
@@ -114,16 +150,34 @@ func (ph *protocolHandler) GetCodec() Codec {
 }
 
 func (ph *protocolHandler) Serve(handler http.Handler, w http.ResponseWriter, r *http.Request) {
+	if ph.shuttingDown() {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	trackingID := getTrackingID(r)
+	if trackingID != "" {
+		if err := ph.verifyTrackingID(trackingID); err != nil {
+			http.Error(w, fmt.Sprintf("Rejected tracking ID: %v", err), http.StatusForbidden)
+			return
+		}
+	}
+	if trackingID != "" && ph.duplicates == DuplicateReject && ph.getConnection(trackingID) != nil {
+		http.Error(w, fmt.Sprintf("Duplicate tracking ID: %s", trackingID), http.StatusConflict)
+		return
+	}
+	if !ph.admitConnection(w, r) {
+		return
+	}
 	conn, err := websocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
+		ph.releaseConnection(r, ph.principal(r))
 		http.Error(w, fmt.Sprintf("Failed to upgrade: %v", err), http.StatusInternalServerError)
 		return
 	}
 	conn.SetCloseHandler(func(code int, text string) error {
 		conn.Close()
-		trackingID := ph.deleteConnection(conn)
-		ph.log.Printf("disconnected code=%d, trackingID=%s", code, trackingID)
-		ph.mediator.UnsubscribeAll(trackingID)
+		// the client closing its connection does not resume its session
+		ph.disconnected(conn, code, false)
 		return nil
 	})
 	if ph.heartbeat > 0 {
@@ -140,13 +194,15 @@ func (ph *protocolHandler) Serve(handler http.Handler, w http.ResponseWriter, r
 		})
 	}
 
-	connlock := &sync.Mutex{}
 	baseURI := getBaseURI(r)
-	trackingID := getTrackingID(r)
 	if trackingID == "" {
-		trackingID = uuid.NewV4().String()
+		trackingID = ph.newTrackingID()
+	} else {
+		trackingID = ph.resolveDuplicate(trackingID)
 	}
-	ph.addConnetion(conn, trackingID)
+	c := ph.addConnetion(conn, trackingID, r)
+	connlock := c.connlock
+	resumed := ph.resumeSession(trackingID)
 
 	ph.log.Printf("connected at baseURI=%s, trackingID=%s", baseURI, trackingID)
 
@@ -176,15 +232,28 @@ func (ph *protocolHandler) Serve(handler http.Handler, w http.ResponseWriter, r
 			mt, p, err := conn.ReadMessage()
 			if err != nil {
 				conn.Close()
+				// the session of the connection that is broken after its handshake is resumable
+				ph.disconnected(conn, websocket.CloseAbnormalClosure, handshaked)
 				break
 			}
+			atomic.AddUint64(&c.received, 1)
 			if handshaked {
-				ph.serve(handler, baseURI, trackingID, mt, p, conn, connlock)
-			} else if err := ph.handshake(p, trackingID, conn); err != nil {
+				ph.serve(handler, baseURI, trackingID, mt, p, c, connlock)
+			} else if err := ph.lockedHandshake(p, trackingID, resumed, c, connlock); err != nil {
 				conn.Close()
 				break
 			} else {
 				handshaked = true
+				atomic.StoreInt32(&c.handshaked, 1)
+				if sa, ok := ph.mediator.(subscriptionAttacher); ok {
+					sa.attach(trackingID, func(rid string, mediaType string) http.ResponseWriter {
+						resp := &responseWriter{id: rid, messageType: websocket.TextMessage, code: http.StatusOK, headers: make(http.Header), conn: c, connlock: connlock, codec: ph.codec}
+						if mediaType != "" {
+							resp.headers.Set("Content-Type", mediaType)
+						}
+						return resp
+					})
+				}
 			}
 		}
 		if heartbeatstop != nil {
@@ -207,7 +276,12 @@ type connectionWriter interface {
 	WriteJSON(v interface{}) error
 }
 
-func (ph *protocolHandler) handshake(p []byte, trackingID string, conn connectionWriter) error {
+// headersWriter is implemented by the response writer that can write the swaggersocket headers in addition to the body
+type headersWriter interface {
+	writeWithHeaders(headers map[string]interface{}, body []byte) (int, error)
+}
+
+func (ph *protocolHandler) handshake(p []byte, trackingID string, resumed bool, conn connectionWriter) error {
 	var hr *HandshakeRequest
 	if err := json.Unmarshal(p, &hr); err != nil {
 		return err
@@ -218,12 +292,20 @@ func (ph *protocolHandler) handshake(p []byte, trackingID string, conn connectio
 		}
 		return errVersionMismatch
 	}
-	if err := conn.WriteJSON(&HandshakeResponse{Version: ProtocolVersion, TrackingID: trackingID}); err != nil {
+	if err := conn.WriteJSON(&HandshakeResponse{Version: ProtocolVersion, TrackingID: trackingID, Resumed: resumed}); err != nil {
 		ph.log.Printf("Failed to write the handshake response: %s", err.Error())
 	}
 	return nil
 }
 
+// lockedHandshake performs the handshake holding the write lock of the connection, which may be written concurrently
+// during the shutdown
+func (ph *protocolHandler) lockedHandshake(p []byte, trackingID string, resumed bool, conn connectionWriter, connlock *sync.Mutex) error {
+	connlock.Lock()
+	defer connlock.Unlock()
+	return ph.handshake(p, trackingID, resumed, conn)
+}
+
 func (ph *protocolHandler) serve(handler http.Handler, baseURI string, trackingID string, mtype int, p []byte, conn connectionWriter, connlock *sync.Mutex) {
 	headers, body, err := ph.codec.DecodeSwaggerSocketMessage(p)
 	if err != nil {
@@ -231,13 +313,38 @@ func (ph *protocolHandler) serve(handler http.Handler, baseURI string, trackingI
 		return
 	}
 
-	cont := getBoolHeader(headers, "continue")
 	rid := getStringHeader(headers, "id")
+	if ph.respond(trackingID, rid, headers, body) {
+		// the response to the call to the client
+		return
+	}
+	if did, ok := headers["ack"].(float64); ok {
+		// the acknowledgement of a message delivered to the subscription
+		if da, ok := ph.mediator.(deliveryAcknowledger); ok {
+			da.acknowledge(buildRequestKey(trackingID, rid), uint64(did))
+		}
+		return
+	}
+
+	cont := getBoolHeader(headers, "continue")
+	if _, ok := ph.continued[rid]; !ok && ph.shuttingDown() {
+		// no new requests are served during the shutdown while the pending continued uploads are completed
+		resp := newHTTPResponse(rid, mtype, conn, connlock, ph.codec)
+		resp.Header().Set("Content-Type", "text/plain")
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		if _, err = resp.Write([]byte("shutting_down")); err != nil {
+			ph.log.Printf("Failed to write the unavailable response: %s", err.Error())
+		}
+		return
+	}
 	if cwriter, ok := ph.continued[rid]; !ok && cont {
 		// for the first segment of a new continued series, dispatch it asynchronously to the handler and write the data to its writer
 		creader, cwriter := io.Pipe()
 		ph.continued[rid] = cwriter
+		// the upload is counted before its handler starts so that the shutdown waits for it
+		atomic.AddInt64(&ph.inflight, 1)
 		go func() {
+			defer atomic.AddInt64(&ph.inflight, -1)
 			req := newHTTPRequest(baseURI, trackingID, rid, headers, creader)
 			resp := newHTTPResponse(rid, mtype, conn, connlock, ph.codec)
 			handler.ServeHTTP(resp, req)
@@ -261,104 +368,464 @@ func (ph *protocolHandler) serve(handler http.Handler, baseURI string, trackingI
 		// for a non-continued single request, dispatch it the handler
 		req := newHTTPRequest(baseURI, trackingID, rid, headers, bytes.NewReader(body))
 		resp := newHTTPResponse(rid, mtype, conn, connlock, ph.codec)
-		handler.ServeHTTP(resp, req)
+		ph.serveHTTP(handler, resp, req)
 	}
 }
 
-func (ph *protocolHandler) addConnetion(conn *websocket.Conn, trackingID string) {
+// addConnetion adds the connection admitted by admitConnection, whose slot is released at its removal
+func (ph *protocolHandler) addConnetion(conn *websocket.Conn, trackingID string, r *http.Request) *connection {
+	c := newConnection(conn, trackingID, r, ph.principal(r))
 	ph.Lock()
 	defer ph.Unlock()
-	ph.connections[conn] = trackingID
+	ph.connections[conn] = c
+	ph.tracking[trackingID] = c
+	return c
 }
 
-func (ph *protocolHandler) deleteConnection(conn *websocket.Conn) string {
+// deleteConnection removes the connection and returns its tracking ID or false if the connection is already removed
+func (ph *protocolHandler) deleteConnection(conn *websocket.Conn) (string, bool) {
 	ph.Lock()
 	defer ph.Unlock()
-	trackingID := ph.connections[conn]
+	c, ok := ph.connections[conn]
+	if !ok {
+		return "", false
+	}
 	delete(ph.connections, conn)
-	return trackingID
+	if ph.tracking[c.trackingID] == c {
+		delete(ph.tracking, c.trackingID)
+	}
+	c.cancelCalls()
+	ph.releaseConnection(c.request, c.principal)
+	return c.trackingID, true
+}
+
+// getConnection returns the connection identified by the tracking ID
+func (ph *protocolHandler) getConnection(trackingID string) *connection {
+	ph.RLock()
+	defer ph.RUnlock()
+	return ph.tracking[trackingID]
+}
+
+// getRequest returns the websocket upgrade request of the connection identified by the tracking ID
+func (ph *protocolHandler) getRequest(trackingID string) *http.Request {
+	if c := ph.getConnection(trackingID); c != nil {
+		return c.request
+	}
+	return nil
 }
 
 type defaultResponseMediator struct {
 	// subscriptionid -> reusableresponder
 	responders map[string]*ReusableResponder
-	// topic -> subscriptionid set
-	topicsubs map[string]map[string]struct{}
-	// subscriptionid -> topic
-	substopics map[string]string
+	// trackingid -> subscriptionid set
+	byTracking map[string]keySet
+	// name -> subscriptionid set
+	byName map[string]keySet
+	// topic filter -> subscriptionid set
+	topicsubs *topicTree
+	// subscriptionid -> topic filter set
+	substopics map[string]map[string]struct{}
+	// topic -> retained message
+	retained map[string]*retainedMessage
+	history  HistoryStore
+	durables SubscriptionStore
+	// the last delivery id assigned to a message written to an acknowledged subscription and the epoch sent with the
+	// delivery ids so that the clients tell them apart from those assigned before the server restarted
+	nextDid    uint64
+	epoch      string
+	ackTimeout time.Duration
+	// the limits of the unacknowledged messages per subscription and of their redeliveries
+	maxUnacked      int
+	maxRedeliveries int
+	presence        PresenceEncoder
+	scopedGreetings bool
+	log             Logger
+	evictAfter      int
+	producers       map[string]runtime.Producer
+	authorizer      TopicAuthorizer
+	policies        []*topicPolicy
+	// returns the websocket upgrade request of the connection
+	connection func(trackingID string) *http.Request
+	observers  []Observer
+	// the notifications queued for the observers while the lock is held
+	notifications []func(o Observer)
+	// set while a flush is sending the notifications
+	dispatching bool
+	// the messages posted to the subscribers while the lock is held, which are written by flush
+	pending outbox
+	// the lock guards the subscriptions, their indices and the topic tree together as the presence, the observers and
+	// the durable subscriptions need them changed consistently. No subscriber is written while it is held, so that the
+	// exclusive sections only touch the subscriptions of the changed connection, name or topic
 	sync.RWMutex
 }
 
-// NewDefaultResponseMediator returns a new default ResponseMediator
-func NewDefaultResponseMediator() ResponseMediator {
-	return &defaultResponseMediator{responders: make(map[string]*ReusableResponder), topicsubs: make(map[string]map[string]struct{}), substopics: make(map[string]string)}
+// MediatorOption represents an optional setting of the default ResponseMediator
+type MediatorOption func(*defaultResponseMediator)
+
+// MediatorOptionLogger sets the logger of the mediator, which otherwise uses the logger of the protocol handler it is
+// configured for. This option is needed to log the failures of restoring the durable subscriptions
+func MediatorOptionLogger(logger Logger) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.log = logger
+	}
+}
+
+// mediatorLoggable is implemented by the mediator to use the logger configured for the protocol handler
+type mediatorLoggable interface {
+	setLogger(logger Logger)
+}
+
+func (m *defaultResponseMediator) setLogger(logger Logger) {
+	if m.log == defaultLogger {
+		m.log = logger
+	}
+}
+
+// MediatorOptionHistory sets the history store that assigns the sequence numbers to the messages written to the names
+// and topics and keeps them for the replay to the subscribers using SubscribeOptionSince
+func MediatorOptionHistory(store HistoryStore) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.history = store
+	}
 }
 
-func (m *defaultResponseMediator) Subscribe(key string, name string, responder middleware.Responder, hello []byte, bye []byte) middleware.Responder {
+type retainedMessage struct {
+	data    []byte
+	expires time.Time
+}
+
+func (rm *retainedMessage) expired(now time.Time) bool {
+	return !rm.expires.IsZero() && now.After(rm.expires)
+}
+
+// responseListener is implemented by the mediator to deliver the messages that follow the initial response
+type responseListener interface {
+	responded(r *ReusableResponder)
+}
+
+// MediatorOptionSubscriptionStore sets the store that persists the durable subscriptions made using SubscribeOptionDurable.
+// The stored subscriptions are restored as offline subscriptions that queue their messages until their subscribers reconnect
+func MediatorOptionSubscriptionStore(store SubscriptionStore) MediatorOption {
+	return func(m *defaultResponseMediator) {
+		m.durables = store
+	}
+}
+
+// subscriptionAttacher is implemented by the mediator to reattach the durable and parked subscriptions of the reconnected subscriber
+type subscriptionAttacher interface {
+	attach(trackingID string, newWriter func(rid string, mediaType string) http.ResponseWriter)
+}
+
+// NewDefaultResponseMediator returns a new default ResponseMediator configured with the optional settings
+func NewDefaultResponseMediator(opts ...MediatorOption) ResponseMediator {
+	m := &defaultResponseMediator{responders: make(map[string]*ReusableResponder), byTracking: make(map[string]keySet), byName: make(map[string]keySet), topicsubs: newTopicTree(), substopics: make(map[string]map[string]struct{}),
+		retained: make(map[string]*retainedMessage), ackTimeout: defaultAckTimeout, evictAfter: defaultEvictAfter,
+		maxUnacked: defaultMaxUnacked, maxRedeliveries: defaultMaxRedeliveries,
+		producers: defaultProducers(), log: defaultLogger, epoch: strconv.FormatInt(time.Now().UnixNano(), 36)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.durables != nil {
+		m.restoreDurables()
+	}
+	m.flush()
+	return m
+}
+
+// restoreDurables restores the stored durable subscriptions as offline subscriptions
+func (m *defaultResponseMediator) restoreDurables() {
+	subs, err := m.durables.LoadAll()
+	if err != nil {
+		m.log.Printf("failed to load the durable subscriptions: %s", err.Error())
+		return
+	}
+	for _, sub := range subs {
+		key := buildRequestKey(sub.TrackingID, sub.RequestID)
+		rr := NewReusableResponder(sub.Name, sub.Topic, nil, m, nil, nil)
+		rr.key = key
+		rr.durable = true
+		rr.offline = true
+		rr.mediaType = sub.MediaType
+		for _, t := range sub.Topics {
+			m.joinTopic(key, t)
+		}
+		m.addResponder(key, rr)
+	}
+}
+
+// saveDurable saves the current state of the durable subscription
+func (m *defaultResponseMediator) saveDurable(r *ReusableResponder) {
+	if m.durables == nil || !r.durable {
+		return
+	}
+	trackingID, rid := splitRequestKey(r.key)
+	sub := &DurableSubscription{TrackingID: trackingID, RequestID: rid, Name: r.name, Topic: r.topic, MediaType: r.mediaType}
+	for t := range m.substopics[r.key] {
+		sub.Topics = append(sub.Topics, t)
+	}
+	sort.Strings(sub.Topics)
+	if err := m.durables.Save(sub); err != nil {
+		m.log.Printf("failed to save the durable subscription: %s", err.Error())
+	}
+}
+
+// deleteDurable deletes the durable subscription and its queued messages
+func (m *defaultResponseMediator) deleteDurable(r *ReusableResponder) {
+	if m.durables == nil || !r.durable {
+		return
+	}
+	if err := m.durables.Delete(splitRequestKey(r.key)); err != nil {
+		m.log.Printf("failed to delete the durable subscription: %s", err.Error())
+	}
+}
+
+// enqueue queues the message for the offline durable subscriber or buffers it for the parked subscriber
+func (m *defaultResponseMediator) enqueue(r *ReusableResponder, headers map[string]interface{}, data []byte) {
+	if m.durables == nil || !r.durable {
+		r.buffer(headers, data)
+		return
+	}
+	trackingID, rid := splitRequestKey(r.key)
+	if err := m.durables.Enqueue(trackingID, rid, &QueuedMessage{Headers: headers, Data: data}); err != nil {
+		m.log.Printf("failed to queue the message: %s", err.Error())
+	}
+}
+
+func (m *defaultResponseMediator) attach(trackingID string, newWriter func(rid string, mediaType string) http.ResponseWriter) {
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.byTracking[trackingID] {
+		r := m.responders[key]
+		_, rid := splitRequestKey(key)
+		if !r.offline {
+			continue
+		}
+		r.writer = newWriter(rid, r.mediaType)
+		r.offline = false
+		m.join(r)
+		m.redeliverAll(r)
+		queued := r.unbuffer()
+		if m.durables != nil && r.durable {
+			var err error
+			if queued, err = m.durables.Dequeue(trackingID, rid); err != nil {
+				m.log.Printf("failed to dequeue the messages: %s", err.Error())
+			}
+		}
+		for _, msg := range queued {
+			m.deliverPolicy(r, nil, msg.Headers, msg.Data, nil) //nolint:errcheck
+		}
+	}
+}
+
+func (m *defaultResponseMediator) Subscribe(key string, name string, responder middleware.Responder, hello []byte, bye []byte, opts ...SubscribeOption) middleware.Responder {
+	if err := m.authorize(key, ActionSubscribe, "", name); err != nil {
+		return &errorResponder{code: http.StatusForbidden, response: err.Error()}
+	}
 	rr := NewReusableResponder(name, "", responder, m, hello, bye)
+	rr.key = key
+	rr.configure(newSubscribeOptions(opts))
+	// the subscription cannot outlive its subscriber without the store to keep it
+	rr.durable = rr.durable && m.durables != nil
+	defer m.flush()
 	m.Lock()
 	defer m.Unlock()
-	m.responders[key] = rr
+	m.addResponder(key, rr)
+	m.startExpiry(rr)
+	m.notifySubscribe(key, rr)
 	return rr
 }
 
-func (m *defaultResponseMediator) SubscribeTopic(key string, topic string, name string, responder middleware.Responder, hello []byte, bye []byte) middleware.Responder {
+func (m *defaultResponseMediator) SubscribeTopic(key string, topic string, name string, responder middleware.Responder, hello []byte, bye []byte, opts ...SubscribeOption) middleware.Responder {
+	if err := validateTopicFilter(topic); err != nil {
+		return &errorResponder{code: http.StatusBadRequest, response: err.Error()}
+	}
+	if err := m.authorize(key, ActionSubscribe, topic, name); err != nil {
+		return &errorResponder{code: http.StatusForbidden, response: err.Error()}
+	}
 	rr := NewReusableResponder(name, topic, responder, m, hello, bye)
+	rr.key = key
+	rr.configure(newSubscribeOptions(opts))
+	// the subscription cannot outlive its subscriber without the store to keep it
+	rr.durable = rr.durable && m.durables != nil
+	defer m.flush()
 	m.Lock()
 	defer m.Unlock()
-	var subs map[string]struct{}
-	if s, ok := m.topicsubs[topic]; !ok {
-		subs = make(map[string]struct{})
-		m.topicsubs[topic] = subs
-	} else {
-		subs = s
-	}
-	subs[key] = struct{}{}
-	m.substopics[key] = topic
-	m.responders[key] = rr
+	m.joinTopic(key, topic)
+	m.addResponder(key, rr)
+	m.startExpiry(rr)
+	m.notifySubscribe(key, rr)
 	return rr
 }
 
+func (m *defaultResponseMediator) JoinTopic(key string, subid string, topic string) error {
+	if err := validateTopicFilter(topic); err != nil {
+		return err
+	}
+	joinid := getDerivedRequestKey(key, subid)
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	r, ok := m.responders[joinid]
+	if !ok {
+		return errNoSubscription
+	}
+	_, joined := m.substopics[joinid][topic]
+	m.joinTopic(joinid, topic)
+	m.saveDurable(r)
+	if !joined && !r.joined.IsZero() {
+		m.announce(r, PresenceJoin, []string{topic})
+	}
+	if r.writer != nil {
+		m.writeRetained(r, map[string]struct{}{topic: {}})
+	}
+	return nil
+}
+
+func (m *defaultResponseMediator) LeaveTopic(key string, subid string, topic string) error {
+	leaveid := getDerivedRequestKey(key, subid)
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	r, ok := m.responders[leaveid]
+	if !ok {
+		return errNoSubscription
+	}
+	_, joined := m.substopics[leaveid][topic]
+	m.leaveTopic(leaveid, topic)
+	m.saveDurable(r)
+	if joined && !r.joined.IsZero() {
+		m.announce(r, PresenceLeave, []string{topic})
+	}
+	return nil
+}
+
+func (m *defaultResponseMediator) joinTopic(key string, topic string) {
+	topics, ok := m.substopics[key]
+	if !ok {
+		topics = make(map[string]struct{})
+		m.substopics[key] = topics
+	}
+	topics[topic] = struct{}{}
+	if m.topicsubs.add(topic, key) {
+		m.notify(func(o Observer) {
+			o.OnTopicCreated(topic)
+		})
+	}
+}
+
+func (m *defaultResponseMediator) leaveTopic(key string, topic string) {
+	if topics, ok := m.substopics[key]; ok {
+		if _, ok := topics[topic]; ok {
+			m.removeTopic(key, topic)
+			delete(topics, topic)
+		}
+		if len(topics) == 0 {
+			delete(m.substopics, key)
+		}
+	}
+}
+
+func (m *defaultResponseMediator) leaveTopics(key string) {
+	for topic := range m.substopics[key] {
+		m.removeTopic(key, topic)
+	}
+	delete(m.substopics, key)
+}
+
+func (m *defaultResponseMediator) removeTopic(key string, topic string) {
+	if m.topicsubs.remove(topic, key) {
+		m.notify(func(o Observer) {
+			o.OnTopicEmpty(topic)
+		})
+	}
+}
+
 func (m *defaultResponseMediator) Unsubscribe(key string, subid string) {
 	unsubid := getDerivedRequestKey(key, subid)
+	defer m.flush()
 	m.Lock()
 	defer m.Unlock()
 	if r := m.responders[unsubid]; r != nil {
-		if r.bye != nil {
-			if r.topic == "" {
-				m.write("*", r.bye)
-			} else {
-				m.writeTopic("*", r.bye)
-			}
+		m.writeEnd(r, EndReasonUnsubscribed)
+		m.unsubscribe(unsubid, r)
+	}
+}
+
+// unsubscribe removes the subscription after broadcasting its bye message
+func (m *defaultResponseMediator) unsubscribe(key string, r *ReusableResponder) {
+	m.writeBye(r)
+	m.notifyUnsubscribe(key, r)
+	topics := m.presenceTopics(r)
+	m.leaveTopics(key)
+	m.removeResponder(key, r)
+	r.stopDeliveries()
+	m.deleteDurable(r)
+	m.leave(r, topics)
+}
+
+func (m *defaultResponseMediator) writeBye(r *ReusableResponder) {
+	m.writeGreeting(r, m.presenceTopics(r), r.bye, false)
+}
+
+// writeGreeting writes the hello or bye message of the subscription to the subscribers of its topics if the greetings
+// are scoped. Otherwise, the message of a subscription without topics or, if everyone is set, of any subscription is
+// broadcast to all the subscribers and the message of a topic subscription to all the topic subscribers
+func (m *defaultResponseMediator) writeGreeting(r *ReusableResponder, topics []string, data []byte, everyone bool) {
+	if data == nil {
+		return
+	}
+	switch {
+	case m.scopedGreetings && (len(topics) != 1 || topics[0] != ""):
+		for _, topic := range topics {
+			headers := map[string]interface{}{"topic": topic}
+			m.forEachPresenceSubscriber(r.name, topic, func(key string) {
+				m.deliverPolicy(m.responders[key], nil, headers, data, nil) //nolint:errcheck
+			})
 		}
-		if t, ok := m.substopics[unsubid]; ok {
-			delete(m.topicsubs[t], unsubid)
+	case r.topic == "" || everyone:
+		for _, s := range m.responders {
+			m.deliverPolicy(s, nil, nil, data, nil) //nolint:errcheck
 		}
-		delete(m.responders, unsubid)
-
+	default:
+		m.forEachTopicSubscriber("*", func(key string) {
+			m.deliverPolicy(m.responders[key], nil, nil, data, nil) //nolint:errcheck
+		})
 	}
 }
 
 func (m *defaultResponseMediator) UnsubscribeAll(trackingID string) {
+	defer m.flush()
 	m.Lock()
 	defer m.Unlock()
-	var byebye [][]byte
-	for key := range m.responders {
-		if strings.HasPrefix(key, trackingID) {
-			if r := m.responders[key]; r != nil {
-				if t, ok := m.substopics[key]; ok {
-					delete(m.topicsubs[t], key)
-				}
-				delete(m.responders, key)
-				if r.bye != nil {
-					byebye = append(byebye, r.bye)
-				}
-			}
+	var byebye []*ReusableResponder
+	leaving := make(map[*ReusableResponder][]string)
+	for _, key := range m.byTracking[trackingID].keys() {
+		r := m.responders[key]
+		leaving[r] = m.presenceTopics(r)
+		if r.durable {
+			// keep the durable subscription to queue the messages until its subscriber reconnects
+			r.offline = true
+			r.writer = nil
+		} else {
+			m.notifyUnsubscribe(key, r)
+			m.leaveTopics(key)
+			m.removeResponder(key, r)
+			r.stopDeliveries()
+		}
+		if r.bye != nil {
+			byebye = append(byebye, r)
 		}
 	}
-	for _, bye := range byebye {
-		m.write("*", bye)
+	for _, r := range byebye {
+		m.writeGreeting(r, leaving[r], r.bye, true)
 	}
+	for r, topics := range leaving {
+		m.leave(r, topics)
+	}
+	m.notify(func(o Observer) {
+		o.OnConnectionGone(trackingID)
+	})
 }
 
 func (m *defaultResponseMediator) Subscribed() []string {
@@ -379,72 +846,377 @@ func (m *defaultResponseMediator) SubscribedTopics() []string {
 	topics := make([]string, 0)
 	m.RLock()
 	defer m.RUnlock()
-	for t := range m.topicsubs {
-		topics = append(topics, t)
-	}
+	m.topicsubs.walk(func(filter string, _ map[string]struct{}) {
+		topics = append(topics, filter)
+	})
 	return topics
 }
 
 func (m *defaultResponseMediator) SubscribedTopic(topic string) []string {
+	subs, err := m.SubscribedTopicFor("", topic)
+	if err != nil {
+		m.log.Printf("failed to list the subscribers: %s", err.Error())
+	}
+	return subs
+}
+
+func (m *defaultResponseMediator) SubscribedTopicFor(key string, topic string) ([]string, error) {
+	if err := m.authorize(key, ActionList, topic, ""); err != nil {
+		return nil, err
+	}
 	subs := make([]string, 0)
 	seen := make(map[string]struct{})
 	m.RLock()
 	defer m.RUnlock()
-	if ss, ok := m.topicsubs[topic]; ok {
-		for s := range ss {
-			rname := m.responders[s].name
-			if _, ok := seen[rname]; !ok {
-				subs = append(subs, rname)
+	m.forEachTopicSubscriber(topic, func(s string) {
+		rname := m.responders[s].name
+		if _, ok := seen[rname]; !ok {
+			seen[rname] = struct{}{}
+			subs = append(subs, rname)
+		}
+	})
+	return subs, nil
+}
+
+func (m *defaultResponseMediator) Write(name string, data []byte) (*DeliveryReport, error) {
+	return m.WriteFrom("", name, data)
+}
+
+func (m *defaultResponseMediator) WriteFrom(key string, name string, data []byte) (*DeliveryReport, error) {
+	if err := m.authorize(key, ActionPublish, "", name); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	out := &outbox{}
+	m.RLock()
+	m.write(name, data, "", report, out)
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
+
+func (m *defaultResponseMediator) WriteExcept(name string, data []byte, except string) (*DeliveryReport, error) {
+	if err := m.authorize(except, ActionPublish, "", name); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	out := &outbox{}
+	m.RLock()
+	m.write(name, data, trackingIDOf(except), report, out)
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
+
+// write writes to the named subscribers except for those of the connection identified by the except tracking ID
+func (m *defaultResponseMediator) write(name string, data []byte, except string, report *DeliveryReport, out *outbox) {
+	var headers map[string]interface{}
+	if name != "*" {
+		headers = m.appendHistory(nameStream(name), data)
+	}
+	if name == "*" {
+		for key, r := range m.responders {
+			if !excluded(key, except) {
+				report.record(r, m.deliver(r, headers, data, out))
 			}
 		}
+		return
+	}
+	for key := range m.byName[name] {
+		if !excluded(key, except) {
+			r := m.responders[key]
+			report.record(r, m.deliver(r, headers, data, out))
+		}
 	}
-	return subs
 }
 
-func (m *defaultResponseMediator) Write(name string, data []byte) error {
+func (m *defaultResponseMediator) WriteTo(trackingID string, data []byte) (*DeliveryReport, error) {
+	trackingID = trackingIDOf(trackingID)
+	report := &DeliveryReport{}
+	out := &outbox{}
 	m.RLock()
-	defer m.RUnlock()
-	m.write(name, data)
+	for key := range m.byTracking[trackingID] {
+		r := m.responders[key]
+		report.record(r, m.deliver(r, nil, data, out))
+	}
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
+
+// trackingIDOf returns the tracking ID of the request key or the tracking ID itself
+func trackingIDOf(key string) string {
+	trackingID, _ := splitRequestKey(key)
+	return trackingID
+}
+
+// excluded checks if the subscription belongs to the connection identified by the except tracking ID
+func excluded(key string, except string) bool {
+	return except != "" && trackingIDOf(key) == except
+}
+
+// deliver writes the message to the subscriber or queues it while the durable subscriber is offline, following the
+// delivery policy of the subscription or its topic. The write is deferred to the outbox, which is sent after the
+// mediator's lock is released. It returns errNotDelivered if the message is not meant for the subscriber yet or
+// filtered out, errThrottled or errDropped if the policy holds the message back or drops it, errDeferred if the write
+// is deferred, or the write error
+func (m *defaultResponseMediator) deliver(r *ReusableResponder, headers map[string]interface{}, data []byte, out *outbox) error {
+	return m.deliverPolicy(r, m.policyOf(r, headers), headers, data, out)
+}
+
+// deliverPolicy delivers the message following the policy, which delivers every message when nil. The message is
+// posted to the mediator's pending outbox when out is nil
+func (m *defaultResponseMediator) deliverPolicy(r *ReusableResponder, policy *DeliveryPolicy, headers map[string]interface{}, data []byte, out *outbox) error {
+	if !r.accepts(data) {
+		return errNotDelivered
+	}
+	if r.offline {
+		m.enqueue(r, headers, data)
+		return nil
+	}
+	if !r.ready(headers) {
+		return errNotDelivered
+	}
+	if policy != nil {
+		return m.throttle(r, policy, headers, data, out)
+	}
+	return m.writeLater(r, headers, data, out)
+}
+
+// writeLater posts the message to be written to the subscriber's current writer when the outbox is sent. Without the
+// outbox, the message is posted to the mediator's pending outbox sent by flush, and the caller must hold the
+// mediator's exclusive lock
+func (m *defaultResponseMediator) writeLater(r *ReusableResponder, headers map[string]interface{}, data []byte, out *outbox) error {
+	if out == nil {
+		out = &m.pending
+	}
+	out.post(&outgoing{r: r, writer: r.writer, headers: headers, data: data})
+	return errDeferred
+}
+
+// writeNow writes the message to the subscriber through the writer and records the outcome
+func (m *defaultResponseMediator) writeNow(r *ReusableResponder, w http.ResponseWriter, headers map[string]interface{}, data []byte) error {
+	var err error
+	if r.acknowledged {
+		err = m.writeAcknowledged(r, w, headers, data)
+	} else {
+		_, err = r.writeWithHeadersTo(w, headers, data)
+	}
+	r.recordDelivery(err)
+	if err != nil {
+		m.log.Printf("failed to write: %s", err.Error())
+		atomic.AddInt32(&r.failures, 1)
+		return err
+	}
+	atomic.StoreInt32(&r.failures, 0)
 	return nil
 }
-func (m *defaultResponseMediator) write(name string, data []byte) {
-	for _, r := range m.responders {
-		if name == "*" || name == r.name {
-			if _, err := r.Write(data); err != nil {
-				// log error TODO use the cofigured logger instead
-				defaultLogger.Printf("failed to write: %s", err.Error())
-			}
+
+func (m *defaultResponseMediator) WriteTopic(topic string, data []byte) (*DeliveryReport, error) {
+	return m.WriteTopicFrom("", topic, data)
+}
+
+func (m *defaultResponseMediator) WriteTopicFrom(key string, topic string, data []byte) (*DeliveryReport, error) {
+	if topic != "*" {
+		if err := validateTopicName(topic); err != nil {
+			return nil, err
 		}
 	}
+	if err := m.authorize(key, ActionPublish, topic, ""); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	out := &outbox{}
+	m.RLock()
+	m.writeTopic(topic, data, "", report, out)
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
 }
 
-func (m *defaultResponseMediator) WriteTopic(topic string, data []byte) error {
+func (m *defaultResponseMediator) WriteTopicExcept(topic string, data []byte, except string) (*DeliveryReport, error) {
+	if topic != "*" {
+		if err := validateTopicName(topic); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.authorize(except, ActionPublish, topic, ""); err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	out := &outbox{}
 	m.RLock()
-	defer m.RUnlock()
-	m.writeTopic(topic, data)
+	m.writeTopic(topic, data, trackingIDOf(except), report, out)
+	m.RUnlock()
+	m.send(out, report)
+	m.evictFailed(report)
+	return report, nil
+}
+
+func (m *defaultResponseMediator) WriteTopicRetained(topic string, data []byte, ttl time.Duration) error {
+	return m.WriteTopicRetainedFrom("", topic, data, ttl)
+}
+
+func (m *defaultResponseMediator) WriteTopicRetainedFrom(key string, topic string, data []byte, ttl time.Duration) error {
+	if err := validateTopicName(topic); err != nil {
+		return err
+	}
+	if err := m.authorize(key, ActionPublish, topic, ""); err != nil {
+		return err
+	}
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for t, rm := range m.retained {
+		if rm.expired(now) {
+			delete(m.retained, t)
+		}
+	}
+	if len(data) == 0 {
+		delete(m.retained, topic)
+		return nil
+	}
+	rm := &retainedMessage{data: data}
+	if ttl > 0 {
+		rm.expires = now.Add(ttl)
+	}
+	m.retained[topic] = rm
+	m.writeTopic(topic, data, "", nil, nil)
 	return nil
 }
 
-func (m *defaultResponseMediator) writeTopic(topic string, data []byte) {
-	if topic == "*" {
-		for _, ss := range m.topicsubs {
-			for s := range ss {
-				if _, err := m.responders[s].Write(data); err != nil {
-					// log error TODO use the cofigured logger instead
-					defaultLogger.Printf("failed to write: %s", err.Error())
-				}
-			}
+func (m *defaultResponseMediator) ClearRetained(topic string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.retained, topic)
+}
+
+func (m *defaultResponseMediator) responded(r *ReusableResponder) {
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	m.saveDurable(r)
+	if m.responders[r.key] == r {
+		m.writeGreeting(r, m.presenceTopics(r), r.hello, false)
+		m.join(r)
+	}
+	if r.replaying {
+		m.replayHistory(r)
+		return
+	}
+	if topics, ok := m.substopics[r.key]; ok {
+		m.writeRetained(r, topics)
+	}
+}
+
+// appendHistory appends the data to the history stream and returns the headers carrying its sequence number
+func (m *defaultResponseMediator) appendHistory(stream string, data []byte) map[string]interface{} {
+	if m.history == nil {
+		return nil
+	}
+	seq, err := m.history.Append(stream, data)
+	if err != nil {
+		m.log.Printf("failed to append to history: %s", err.Error())
+		return nil
+	}
+	return map[string]interface{}{"seq": seq}
+}
+
+// replayHistory posts the messages of the subscription's topic or name that follow the requested sequence number.
+// The live messages are held back from the subscription until the replay is over so that no message is duplicated.
+// The caller must hold the mediator's exclusive lock
+func (m *defaultResponseMediator) replayHistory(r *ReusableResponder) {
+	r.replaying = false
+	if m.history == nil {
+		return
+	}
+	var stream, topic string
+	if r.topic == "" {
+		stream = nameStream(r.name)
+	} else if validateTopicName(r.topic) == nil {
+		stream = topicStream(r.topic)
+		topic = r.topic
+	} else {
+		// no replay for the topic filters with wildcards
+		return
+	}
+	messages, err := m.history.Since(stream, r.since)
+	if err != nil {
+		m.log.Printf("failed to read from history: %s", err.Error())
+		return
+	}
+	for _, msg := range messages {
+		if !r.accepts(msg.Data) {
+			continue
+		}
+		headers := map[string]interface{}{"seq": msg.Seq}
+		if topic != "" {
+			headers["topic"] = topic
 		}
-	} else if ss, ok := m.topicsubs[topic]; ok {
-		for s := range ss {
-			if _, err := m.responders[s].Write(data); err != nil {
-				// log error TODO use the cofigured logger instead
-				defaultLogger.Printf("failed to write: %s", err.Error())
+		m.pending.post(&outgoing{r: r, writer: r.writer, headers: headers, data: msg.Data, kind: outgoingPush})
+	}
+}
+
+// writeRetained posts the unexpired retained messages of the topics matching the specified topic filters. The caller
+// must hold the mediator's exclusive lock
+func (m *defaultResponseMediator) writeRetained(r *ReusableResponder, filters map[string]struct{}) {
+	now := time.Now()
+	for topic, rm := range m.retained {
+		if rm.expired(now) || !r.accepts(rm.data) {
+			continue
+		}
+		for filter := range filters {
+			if matchTopicFilter(filter, topic) {
+				m.pending.post(&outgoing{r: r, writer: r.writer, headers: map[string]interface{}{"topic": topic}, data: rm.data, kind: outgoingPush})
+				break
 			}
 		}
 	}
 }
 
+// writeTopic writes to the topic subscribers except for those of the connection identified by the except tracking ID
+func (m *defaultResponseMediator) writeTopic(topic string, data []byte, except string, report *DeliveryReport, out *outbox) {
+	var headers map[string]interface{}
+	if topic != "*" {
+		headers = m.appendHistory(topicStream(topic), data)
+		if headers == nil {
+			headers = make(map[string]interface{})
+		}
+		// let the subscribers of multiple topics distinguish the source topic
+		headers["topic"] = topic
+	}
+	m.forEachTopicSubscriber(topic, func(s string) {
+		if !excluded(s, except) {
+			r := m.responders[s]
+			report.record(r, m.deliver(r, headers, data, out))
+		}
+	})
+}
+
+// forEachTopicSubscriber invokes fn once for each subscription whose topic filter matches the topic or for all
+// topic subscriptions when topic is '*'
+func (m *defaultResponseMediator) forEachTopicSubscriber(topic string, fn func(key string)) {
+	if topic == "*" {
+		for s := range m.substopics {
+			fn(s)
+		}
+		return
+	}
+	// a subscription with several overlapping topic filters must be invoked only once
+	seen := make(map[string]struct{})
+	m.topicsubs.match(topic, func(s string) {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			fn(s)
+		}
+	})
+}
+
 func newHTTPRequest(baseURI string, trackingID string, rid string, headers map[string]interface{}, body io.Reader) *http.Request {
 	uri := fmt.Sprintf("%s%s", baseURI, getStringHeader(headers, "path"))
 	req, _ := http.NewRequest(getStringHeader(headers, "method"), uri, body) //nolint:errcheck
@@ -492,16 +1264,24 @@ func (r *responseWriter) Header() http.Header {
 }
 
 func (r *responseWriter) Write(body []byte) (int, error) {
-	// flush the buffer when the content-type header is not set
-	data, err := r.codec.EncodeSwaggerSocketMessage(r.buildHeaders(), body)
+	return r.writeWithHeaders(nil, body)
+}
+
+// writeWithHeaders writes the body with the specified headers added to the response headers
+func (r *responseWriter) writeWithHeaders(headers map[string]interface{}, body []byte) (int, error) {
+	rheaders := r.buildHeaders()
+	for k, v := range headers {
+		rheaders[k] = v
+	}
+	data, err := r.codec.EncodeSwaggerSocketMessage(rheaders, body)
 	if err != nil {
 		return 0, err
 	}
 	r.connlock.Lock()
+	defer r.connlock.Unlock()
 	if err = r.conn.WriteMessage(r.messageType, data); err != nil {
 		return 0, err
 	}
-	r.connlock.Unlock()
 	return len(body), nil
 }
 
@@ -610,6 +1390,14 @@ func getDerivedRequestKey(rkey string, rid string) string {
 	return strings.Split(rkey, "#")[0] + "#" + rid
 }
 
+// splitRequestKey returns the tracking-id and request-id of the specified request key
+func splitRequestKey(rkey string) (string, string) {
+	if p := strings.LastIndex(rkey, "#"); p >= 0 {
+		return rkey[:p], rkey[p+1:]
+	}
+	return rkey, ""
+}
+
 // buildRequestKey returns the string consisting of tracking-id and request-id separaterd by '#'
 func buildRequestKey(trackingid string, reqid string) string {
 	return fmt.Sprintf("%s#%s", trackingid, reqid)
@@ -617,11 +1405,12 @@ func buildRequestKey(trackingid string, reqid string) string {
 
 // NewReusableResponder wraps the original responder to capture the underlining durable connection for later use
 func NewReusableResponder(key string, topic string, r middleware.Responder, mediator ResponseMediator, hello []byte, bye []byte) *ReusableResponder {
-	return &ReusableResponder{name: key, topic: topic, responder: r, mediator: mediator, hello: hello, bye: bye}
+	return &ReusableResponder{name: key, topic: topic, responder: r, mediator: mediator, hello: hello, bye: bye, created: time.Now()}
 }
 
 // ReusableResponder is a middleware.Responder which grab the http.ResponseWriter for later reuse
 type ReusableResponder struct {
+	key       string
 	name      string
 	topic     string
 	responder middleware.Responder
@@ -629,6 +1418,74 @@ type ReusableResponder struct {
 	bye       []byte
 	writer    http.ResponseWriter
 	mediator  ResponseMediator
+	filter    Filter
+	since     uint64
+	replaying bool
+	mediaType string
+	durable   bool
+	offline   bool
+	// delivery id -> unacknowledged message
+	unacked      map[uint64]*pendingDelivery
+	acknowledged bool
+	// the time of joining while the subscriber is present
+	joined   time.Time
+	metadata map[string]interface{}
+	// the number of consecutive write failures
+	failures int32
+	// the producer negotiated for the initial response
+	producer runtime.Producer
+	// the delivery statistics
+	created      time.Time
+	delivered    uint64
+	failed       uint64
+	lastDelivery int64
+	lock         sync.Mutex
+	// the number of the messages pushed to the subscription
+	pushed uint64
+	// the messages buffered while the subscription is parked
+	parked []*QueuedMessage
+	// the delivery policy overriding the topic policies and its state per topic
+	policy    *DeliveryPolicy
+	throttles map[string]*throttle
+	// the expiry settings and their timers
+	ttl         time.Duration
+	idleTimeout time.Duration
+	ttlTimer    *time.Timer
+	idleTimer   *time.Timer
+	// the messages posted to the subscription in their order and the lock held while writing them
+	outq     []*outgoing
+	outlock  sync.Mutex
+	sendlock sync.Mutex
+}
+
+// configure applies the optional subscription settings
+func (r *ReusableResponder) configure(o *subscribeOptions) {
+	r.filter = o.filter
+	r.durable = o.durable
+	r.acknowledged = o.acknowledged
+	r.metadata = o.metadata
+	r.policy = o.policy
+	r.ttl = o.ttl
+	r.idleTimeout = o.idleTimeout
+	if o.since != nil {
+		r.since = *o.since
+		r.replaying = true
+	}
+}
+
+// ready checks if a message with the specified headers can be written to the subscriber, which is not the case before
+// its initial response is written nor for the sequenced messages while the history is being replayed
+func (r *ReusableResponder) ready(headers map[string]interface{}) bool {
+	if r.writer == nil {
+		return false
+	}
+	_, sequenced := headers["seq"]
+	return !(sequenced && r.replaying)
+}
+
+// accepts checks if the data passes the subscription's filter
+func (r *ReusableResponder) accepts(data []byte) bool {
+	return r.filter == nil || r.filter(data)
 }
 
 // WriteResponse writes the initial responseWriter to the responseWriter writer
@@ -637,22 +1494,67 @@ func (r *ReusableResponder) WriteResponse(rw http.ResponseWriter, producer runti
 		r.writer = rw
 	}
 	r.responder.WriteResponse(rw, producer)
-	if r.hello != nil {
+	r.mediaType = rw.Header().Get("Content-Type")
+	r.producer = producer
+	if _, ok := r.mediator.(responseListener); !ok && r.hello != nil {
+		// the mediator not listening to the responses cannot scope the hello message
 		if r.topic == "" {
-			if err := r.mediator.Write("*", r.hello); err != nil {
+			if _, err := r.mediator.Write("*", r.hello); err != nil {
 				// TODO use the cofigured logger instead
 				defaultLogger.Printf("Failed to broadcast hello to subscribers: %s", err.Error())
 			}
 		} else {
-			if err := r.mediator.WriteTopic("*", r.hello); err != nil {
+			if _, err := r.mediator.WriteTopic("*", r.hello); err != nil {
 				// TODO use the cofigured logger instead
 				defaultLogger.Printf("Failed to broadcast hello to topics: %s", err.Error())
 			}
 		}
 	}
+	if rl, ok := r.mediator.(responseListener); ok {
+		rl.responded(r)
+	}
 }
 
 // Write writes the subsequent responseWriter to the responseWriter writer
 func (r *ReusableResponder) Write(b []byte) (int, error) {
 	return r.writer.Write(b)
 }
+
+// writeWithHeadersTo writes the subsequent responseWriter to the writer captured under the mediator's lock with the
+// additional headers when the writer supports them. The message is marked as pushed and numbered in the order of the
+// messages pushed to the subscription
+func (r *ReusableResponder) writeWithHeadersTo(w http.ResponseWriter, headers map[string]interface{}, b []byte) (int, error) {
+	hw, ok := w.(headersWriter)
+	if !ok {
+		return w.Write(b)
+	}
+	pheaders := make(map[string]interface{}, len(headers)+2)
+	for k, v := range headers {
+		pheaders[k] = v
+	}
+	pheaders["push"] = true
+	pheaders["pseq"] = atomic.AddUint64(&r.pushed, 1)
+	return hw.writeWithHeaders(pheaders, b)
+}
+
+// writeEnd posts the terminal message of the subscription with the reason why it ends. The caller must hold the
+// mediator's exclusive lock
+func (m *defaultResponseMediator) writeEnd(r *ReusableResponder, reason string) {
+	if _, ok := r.writer.(headersWriter); !ok || r.offline {
+		return
+	}
+	m.pending.post(&outgoing{r: r, writer: r.writer, headers: map[string]interface{}{"code": http.StatusGone, "end": true, "reason": reason}, kind: outgoingEnd})
+}
+
+// errorResponder is a middleware.Responder that writes an error response (based on middleware/not_implemented.go)
+type errorResponder struct {
+	code     int
+	response interface{}
+}
+
+func (e *errorResponder) WriteResponse(rw http.ResponseWriter, producer runtime.Producer) {
+	rw.WriteHeader(e.code)
+	if err := producer.Produce(rw, e.response); err != nil {
+		defaultLogger.Printf("Failed to write the error response: %s", err.Error())
+	}
+}
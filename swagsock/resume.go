@@ -0,0 +1,100 @@
+package swagsock
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxParkedMessages is the number of the messages buffered for a parked subscription beyond which the oldest ones are discarded
+const maxParkedMessages = 1000
+
+// sessionParker is implemented by the mediator to keep the subscriptions of the abnormally disconnected subscriber
+// during the resume window. The subscriptions are reattached by subscriptionAttacher when the subscriber reconnects
+type sessionParker interface {
+	park(trackingID string)
+}
+
+// park takes the subscriptions of the tracking ID offline to buffer their messages until they are reattached or removed
+func (m *defaultResponseMediator) park(trackingID string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.byTracking[trackingID] {
+		r := m.responders[key]
+		r.offline = true
+		r.writer = nil
+	}
+}
+
+// buffer keeps the message for the parked subscription which is not durable
+func (r *ReusableResponder) buffer(headers map[string]interface{}, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.parked) >= maxParkedMessages {
+		r.parked = r.parked[1:]
+	}
+	r.parked = append(r.parked, &QueuedMessage{Headers: headers, Data: data})
+}
+
+// unbuffer returns and clears the messages buffered while the subscription was parked
+func (r *ReusableResponder) unbuffer() []*QueuedMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	parked := r.parked
+	r.parked = nil
+	return parked
+}
+
+// parkSession keeps the subscriptions of the disconnected tracking ID for the resume window and removes them when
+// the window expires without the client reconnecting
+func (ph *protocolHandler) parkSession(trackingID string) bool {
+	sp, ok := ph.mediator.(sessionParker)
+	if ph.resumeWindow <= 0 || !ok {
+		return false
+	}
+	sp.park(trackingID)
+	ph.Lock()
+	defer ph.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(ph.resumeWindow, func() {
+		ph.Lock()
+		expired := ph.parked[trackingID] == timer
+		if expired {
+			delete(ph.parked, trackingID)
+		}
+		ph.Unlock()
+		if expired {
+			ph.log.Printf("session expired trackingID=%s", trackingID)
+			ph.mediator.UnsubscribeAll(trackingID)
+		}
+	})
+	ph.parked[trackingID] = timer
+	return true
+}
+
+// resumeSession checks if the session of the tracking ID is parked and cancels its expiry
+func (ph *protocolHandler) resumeSession(trackingID string) bool {
+	ph.Lock()
+	defer ph.Unlock()
+	timer, ok := ph.parked[trackingID]
+	if ok {
+		timer.Stop()
+		delete(ph.parked, trackingID)
+	}
+	return ok
+}
+
+// disconnected removes the closed connection and its subscriptions, which are parked for the resume window instead
+// if the connection is resumable
+func (ph *protocolHandler) disconnected(conn *websocket.Conn, code int, resumable bool) {
+	trackingID, ok := ph.deleteConnection(conn)
+	if !ok {
+		return
+	}
+	ph.log.Printf("disconnected code=%d, trackingID=%s", code, trackingID)
+	// the sessions are not resumed by the clients going away during the shutdown
+	if resumable && !ph.shuttingDown() && ph.parkSession(trackingID) {
+		return
+	}
+	ph.mediator.UnsubscribeAll(trackingID)
+}
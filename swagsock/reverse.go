@@ -0,0 +1,145 @@
+package swagsock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// reverseCall is a request sent to the client which is waiting for its response
+type reverseCall struct {
+	body     bytes.Buffer
+	response chan *http.Response
+}
+
+func (ph *protocolHandler) Call(ctx context.Context, trackingID string, r *http.Request) (*http.Response, error) {
+	c := ph.getConnection(trackingID)
+	if c == nil || atomic.LoadInt32(&c.handshaked) == 0 {
+		return nil, errNoConnection
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+	}
+	rid := fmt.Sprintf("s%d", atomic.AddUint64(&ph.nextCall, 1))
+	data, err := ph.codec.EncodeSwaggerSocketMessage(buildRequestHeaders(rid, r), body)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &reverseCall{response: make(chan *http.Response, 1)}
+	c.putCall(rid, call)
+	defer c.removeCall(rid)
+	c.connlock.Lock()
+	err = c.WriteMessage(websocket.TextMessage, data)
+	c.connlock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-call.response:
+		if resp == nil {
+			return nil, errNoConnection
+		}
+		resp.Request = r
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// buildRequestHeaders returns the headers of the request envelope sent to the client
+func buildRequestHeaders(rid string, r *http.Request) map[string]interface{} {
+	headers := map[string]interface{}{"id": rid, "method": r.Method, "path": r.URL.RequestURI()}
+	aheaders := make(map[string]interface{})
+	for name := range r.Header {
+		switch name {
+		case "Content-Type":
+			headers["type"] = r.Header.Get(name)
+		case "Accept":
+			headers["accept"] = r.Header.Get(name)
+		default:
+			aheaders[name] = r.Header.Get(name)
+		}
+	}
+	if len(aheaders) > 0 {
+		headers["headers"] = aheaders
+	}
+	return headers
+}
+
+// respond passes the response envelope sent by the client to the waiting call. It returns false if the envelope
+// is not a response to a pending call, which is then handled as any other envelope
+func (ph *protocolHandler) respond(trackingID string, rid string, headers map[string]interface{}, body []byte) bool {
+	code, ok := headers["code"].(int)
+	if !ok {
+		return false
+	}
+	c := ph.getConnection(trackingID)
+	if c == nil {
+		return false
+	}
+	call := c.getCall(rid)
+	if call == nil {
+		return false
+	}
+	call.body.Write(body)
+	if getBoolHeader(headers, "continue") {
+		return true
+	}
+	resp := &http.Response{StatusCode: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code)), Proto: "HTTP/1.1",
+		ProtoMajor: 1, ProtoMinor: 1, Header: make(http.Header), ContentLength: int64(call.body.Len()),
+		Body: ioutil.NopCloser(bytes.NewReader(call.body.Bytes()))}
+	copyHeaderToHTTPHeaders(headers, "type", resp.Header, "Content-Type")
+	if aheaders, ok := headers["headers"].(map[string]interface{}); ok {
+		for aheader, avalue := range aheaders {
+			if s, ok := avalue.(string); ok {
+				resp.Header.Add(aheader, s)
+			}
+		}
+	}
+	call.response <- resp
+	return true
+}
+
+func (c *connection) putCall(rid string, call *reverseCall) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]*reverseCall)
+	}
+	c.calls[rid] = call
+}
+
+func (c *connection) getCall(rid string) *reverseCall {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls[rid]
+}
+
+func (c *connection) removeCall(rid string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.calls, rid)
+}
+
+// cancelCalls fails the calls waiting for the responses from the disconnected client
+func (c *connection) cancelCalls() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for rid, call := range c.calls {
+		select {
+		case call.response <- nil:
+		default:
+		}
+		delete(c.calls, rid)
+	}
+}
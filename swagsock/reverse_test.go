@@ -0,0 +1,95 @@
+package swagsock
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCall(t *testing.T) {
+	conf := NewConfig()
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(&testEchoHandler{mediator: conf.ResponseMediator}, w, r)
+	}))
+	defer ts.Close()
+
+	transport := NewTransport("ws" + ts.URL[4:] + "?x-tracking-id=" + testTrackingID)
+	defer transport.Close()
+
+	// the client without a handler responds with 501 after the handshake
+	req, _ := http.NewRequest("GET", "/v1/files", nil) //nolint:errcheck
+	resp, err := ph.Call(context.Background(), testTrackingID, req)
+	for i := 0; i < 100 && err == errNoConnection; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resp, err = ph.Call(context.Background(), testTrackingID, req)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	transport.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body) //nolint:errcheck
+		assert.Equal(t, "/v1/confirm", r.URL.Path)
+		assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+		assert.Equal(t, "agent", r.Header.Get("X-Role"))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(append([]byte("confirmed "), b...)) //nolint:errcheck
+	}))
+	req, _ = http.NewRequest("POST", "/v1/confirm", bytes.NewReader([]byte("delete"))) //nolint:errcheck
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Role", "agent")
+	resp, err = ph.Call(context.Background(), testTrackingID, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	b, _ := ioutil.ReadAll(resp.Body) //nolint:errcheck
+	assert.Equal(t, "confirmed delete", string(b))
+
+	// the call waits for the response until the context is done
+	transport.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ph.Call(ctx, testTrackingID, req)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = ph.Call(context.Background(), "unknown", req)
+	assert.Equal(t, errNoConnection, err)
+}
+
+func TestServeResponse(t *testing.T) {
+	conf := NewConfig()
+	ph, ok := CreateProtocolHandler(conf).(*protocolHandler)
+	assert.True(t, ok)
+	r, _ := http.NewRequest("GET", "http://localhost:8091/samples/greeter", nil) //nolint:errcheck
+	assert.True(t, ph.admitConnection(httptest.NewRecorder(), r))
+	c := ph.addConnetion(&websocket.Conn{}, testTrackingID, r)
+	hh := &testHTTPHandler{}
+
+	// the envelope with a code but no pending call is served as a request
+	ph.serve(hh, "/service", testTrackingID, 1, []byte(`{"id":"s1","code":200,"method":"POST","path":"/v1/status"}up`), nil, nil)
+	assert.Equal(t, 1, hh.served)
+	assert.Equal(t, "/service/v1/status", hh.req.RequestURI)
+	assert.Equal(t, "up", hh.body.String())
+
+	// the envelope with the id of a pending call is its response
+	call := &reverseCall{response: make(chan *http.Response, 1)}
+	c.putCall("s1", call)
+	ph.serve(hh, "/service", testTrackingID, 1, []byte(`{"id":"s1","code":202,"type":"text/plain"}done`), nil, nil)
+	assert.Equal(t, 1, hh.served)
+	resp := <-call.response
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	b, _ := ioutil.ReadAll(resp.Body) //nolint:errcheck
+	assert.Equal(t, "done", string(b))
+}
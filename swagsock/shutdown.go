@@ -0,0 +1,90 @@
+package swagsock
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownPollInterval is the interval of checking whether the handlers and connections are drained during the shutdown
+const shutdownPollInterval = 50 * time.Millisecond
+
+func (ph *protocolHandler) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&ph.shutdown, 1)
+	conns := ph.snapshotConnections()
+	ph.log.Printf("shutting down with %d connections", len(conns))
+	goaway, err := ph.codec.EncodeSwaggerSocketMessage(map[string]interface{}{"goaway": true, "delay": ph.goAwayDelay.Milliseconds()}, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range conns {
+		c.connlock.Lock()
+		if err := c.WriteMessage(websocket.TextMessage, goaway); err != nil {
+			ph.log.Printf("Failed to write the goaway message: %s", err.Error())
+		}
+		c.connlock.Unlock()
+	}
+
+	// wait for the requests being served including the continued uploads before closing the connections
+	drained := ph.waitUntil(ctx, func() bool {
+		return atomic.LoadInt64(&ph.inflight) == 0
+	})
+	if drained {
+		closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutdown")
+		for _, c := range conns {
+			if err := c.WriteControl(websocket.CloseMessage, closing, time.Now().Add(closeWriteWait)); err != nil {
+				ph.log.Printf("Failed to write the close message: %s", err.Error())
+			}
+		}
+		drained = ph.waitUntil(ctx, func() bool {
+			return len(ph.snapshotConnections()) == 0
+		})
+	}
+	if drained {
+		return nil
+	}
+	for _, c := range ph.snapshotConnections() {
+		c.Close()
+	}
+	return ctx.Err()
+}
+
+// waitUntil waits until the condition is satisfied and returns true or returns false when the context is done
+func (ph *protocolHandler) waitUntil(ctx context.Context, cond func() bool) bool {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// shuttingDown checks if the protocol handler is being shut down
+func (ph *protocolHandler) shuttingDown() bool {
+	return atomic.LoadInt32(&ph.shutdown) == 1
+}
+
+// snapshotConnections returns the current connections
+func (ph *protocolHandler) snapshotConnections() []*connection {
+	ph.RLock()
+	defer ph.RUnlock()
+	conns := make([]*connection, 0, len(ph.connections))
+	for _, c := range ph.connections {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// serveHTTP invokes the handler counting the requests in flight
+func (ph *protocolHandler) serveHTTP(handler http.Handler, resp http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&ph.inflight, 1)
+	defer atomic.AddInt64(&ph.inflight, -1)
+	handler.ServeHTTP(resp, req)
+}
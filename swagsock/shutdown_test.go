@@ -0,0 +1,102 @@
+package swagsock
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	conf := NewConfig()
+	conf.GoAwayDelay = 3 * time.Second
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body) //nolint:errcheck
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write(b) //nolint:errcheck
+		}), w, r)
+	}))
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"?x-tracking-id="+testTrackingID, nil)
+	assert.NoError(t, err)
+	defer ws.Close() //nolint:staticcheck
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"version":"2.0"}`)))
+	_, _, err = ws.ReadMessage()
+	assert.NoError(t, err)
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","method":"POST","path":"/v1/upload","type":"text/plain","continue":true}hola`)))
+	inflight := &ph.(*protocolHandler).inflight
+	for i := 0; i < 100 && atomic.LoadInt64(inflight) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		errc <- ph.Shutdown(ctx)
+	}()
+	_, message, err := ws.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"delay":3000,"goaway":true}`, string(message))
+
+	// neither new connections nor new requests are accepted
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"2","method":"POST","path":"/v1/upload","type":"text/plain"}hola`)))
+	_, message, err = ws.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"code":503,"id":"2","type":"text/plain"}shutting_down`, string(message))
+
+	// the pending continued upload is completed before the connection is closed
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","continue":false} mundo`)))
+	_, message, err = ws.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"code":200,"id":"1","type":"text/plain"}hola mundo`, string(message))
+	_, _, err = ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	select {
+	case err = <-errc:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "timeout unexpected")
+	}
+	assert.Empty(t, ph.Connections())
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	conf := NewConfig()
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(&testEchoHandler{mediator: conf.ResponseMediator}, w, r)
+	}))
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"?x-tracking-id="+testTrackingID, nil)
+	assert.NoError(t, err)
+	defer ws.Close() //nolint:staticcheck
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"version":"2.0"}`)))
+	_, _, err = ws.ReadMessage()
+	assert.NoError(t, err)
+
+	// the client not answering the close message is disconnected at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ph.Shutdown(ctx))
+	for i := 0; i < 100 && len(ph.Connections()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Empty(t, ph.Connections())
+}
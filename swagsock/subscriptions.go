@@ -0,0 +1,98 @@
+package swagsock
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// matches checks if the subscription satisfies all the non-empty fields of the filter
+func (f *SubscriptionFilter) matches(m *defaultResponseMediator, key string, r *ReusableResponder) bool {
+	trackingID, _ := splitRequestKey(key)
+	if f.TrackingID != "" && f.TrackingID != trackingID {
+		return false
+	}
+	if f.Name != "" && f.Name != r.name {
+		return false
+	}
+	if f.Topic != "" {
+		if _, ok := m.substopics[key][f.Topic]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the keys of the subscriptions that may match the filter using the narrowest index
+func (m *defaultResponseMediator) candidates(filter SubscriptionFilter) []string {
+	switch {
+	case filter.TrackingID != "":
+		return m.byTracking[filter.TrackingID].keys()
+	case filter.Name != "":
+		return m.byName[filter.Name].keys()
+	}
+	keys := make([]string, 0, len(m.responders))
+	for key := range m.responders {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// recordDelivery updates the delivery statistics of the subscription
+func (r *ReusableResponder) recordDelivery(err error) {
+	if err != nil {
+		atomic.AddUint64(&r.failed, 1)
+		return
+	}
+	atomic.AddUint64(&r.delivered, 1)
+	atomic.StoreInt64(&r.lastDelivery, time.Now().UnixNano())
+}
+
+func (m *defaultResponseMediator) Subscriptions(filter SubscriptionFilter) []*SubscriptionInfo {
+	subs := make([]*SubscriptionInfo, 0)
+	m.RLock()
+	defer m.RUnlock()
+	for _, key := range m.candidates(filter) {
+		if r := m.responders[key]; filter.matches(m, key, r) {
+			subs = append(subs, m.subscriptionInfo(key, r))
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Created.Equal(subs[j].Created) {
+			return subs[i].Key < subs[j].Key
+		}
+		return subs[i].Created.Before(subs[j].Created)
+	})
+	return subs
+}
+
+// subscriptionInfo returns the snapshot of the subscription
+func (m *defaultResponseMediator) subscriptionInfo(key string, r *ReusableResponder) *SubscriptionInfo {
+	trackingID, rid := splitRequestKey(key)
+	sub := &SubscriptionInfo{Key: key, TrackingID: trackingID, RequestID: rid, Name: r.name, Topics: make([]string, 0),
+		Created: r.created, Delivered: atomic.LoadUint64(&r.delivered), Failed: atomic.LoadUint64(&r.failed),
+		Durable: r.durable, Offline: r.offline}
+	for topic := range m.substopics[key] {
+		sub.Topics = append(sub.Topics, topic)
+	}
+	sort.Strings(sub.Topics)
+	if ld := atomic.LoadInt64(&r.lastDelivery); ld != 0 {
+		sub.LastDelivery = time.Unix(0, ld)
+	}
+	return sub
+}
+
+func (m *defaultResponseMediator) UnsubscribeMatching(filter SubscriptionFilter) int {
+	defer m.flush()
+	m.Lock()
+	defer m.Unlock()
+	var count int
+	for _, key := range m.candidates(filter) {
+		if r := m.responders[key]; filter.matches(m, key, r) {
+			m.writeEnd(r, EndReasonUnsubscribed)
+			m.unsubscribe(key, r)
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,174 @@
+package swagsock
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// topicLevelSeparator separates the levels of a hierarchical topic name (e.g., site/42/sensor/7/temp)
+	topicLevelSeparator = "/"
+	// topicSingleLevelWildcard matches exactly one topic level (e.g., site/+/sensor/7/temp)
+	topicSingleLevelWildcard = "+"
+	// topicMultiLevelWildcard matches any number of remaining topic levels, including none (e.g., site/42/#)
+	topicMultiLevelWildcard = "#"
+)
+
+var (
+	errInvalidTopicFilter = errors.New("invalid_topic_filter")
+	errInvalidTopicName   = errors.New("invalid_topic_name")
+)
+
+// IsTopicFilter checks if the specified topic contains a wildcard and thus can only be used for subscribing
+func IsTopicFilter(topic string) bool {
+	return strings.ContainsAny(topic, topicSingleLevelWildcard+topicMultiLevelWildcard)
+}
+
+// validateTopicFilter checks if the wildcards are used at the permitted positions of the specified topic filter
+func validateTopicFilter(filter string) error {
+	levels := strings.Split(filter, topicLevelSeparator)
+	for i, level := range levels {
+		switch {
+		case level == topicMultiLevelWildcard:
+			if i != len(levels)-1 {
+				return errInvalidTopicFilter
+			}
+		case level == topicSingleLevelWildcard:
+		case IsTopicFilter(level):
+			// the wildcard must occupy the entire level
+			return errInvalidTopicFilter
+		}
+	}
+	return nil
+}
+
+// validateTopicName checks if the specified topic can be used for publishing
+func validateTopicName(topic string) error {
+	if IsTopicFilter(topic) {
+		return errInvalidTopicName
+	}
+	return nil
+}
+
+// matchTopicFilter checks if the specified topic matches the specified topic filter
+func matchTopicFilter(filter string, topic string) bool {
+	flevels := strings.Split(filter, topicLevelSeparator)
+	tlevels := strings.Split(topic, topicLevelSeparator)
+	for i, flevel := range flevels {
+		if flevel == topicMultiLevelWildcard {
+			return true
+		}
+		if i >= len(tlevels) || (flevel != topicSingleLevelWildcard && flevel != tlevels[i]) {
+			return false
+		}
+	}
+	return len(flevels) == len(tlevels)
+}
+
+// topicTree is a trie of the subscribed topic filters whose levels are the nodes of the tree
+type topicTree struct {
+	root *topicNode
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+	// subscriptionid set
+	subs map[string]struct{}
+}
+
+func newTopicTree() *topicTree {
+	return &topicTree{root: newTopicNode()}
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{children: make(map[string]*topicNode), subs: make(map[string]struct{})}
+}
+
+func (n *topicNode) isEmpty() bool {
+	return len(n.subs) == 0 && len(n.children) == 0
+}
+
+// add adds the subscription key to the specified topic filter and returns true if it is the filter's first subscription
+func (t *topicTree) add(filter string, key string) bool {
+	node := t.root
+	for _, level := range strings.Split(filter, topicLevelSeparator) {
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode()
+			node.children[level] = child
+		}
+		node = child
+	}
+	first := len(node.subs) == 0
+	node.subs[key] = struct{}{}
+	return first
+}
+
+// remove removes the subscription key from the specified topic filter and prunes the emptied nodes. It returns true
+// if the removed subscription was the filter's last one
+func (t *topicTree) remove(filter string, key string) bool {
+	levels := strings.Split(filter, topicLevelSeparator)
+	path := make([]*topicNode, 0, len(levels)+1)
+	node := t.root
+	path = append(path, node)
+	for _, level := range levels {
+		child, ok := node.children[level]
+		if !ok {
+			return false
+		}
+		node = child
+		path = append(path, node)
+	}
+	if _, ok := node.subs[key]; !ok {
+		return false
+	}
+	delete(node.subs, key)
+	last := len(node.subs) == 0
+	for i := len(levels) - 1; i >= 0; i-- {
+		if !path[i+1].isEmpty() {
+			break
+		}
+		delete(path[i].children, levels[i])
+	}
+	return last
+}
+
+// match invokes fn for each subscription key whose topic filter matches the specified topic
+func (t *topicTree) match(topic string, fn func(key string)) {
+	t.root.match(strings.Split(topic, topicLevelSeparator), fn)
+}
+
+func (n *topicNode) match(levels []string, fn func(key string)) {
+	if mw, ok := n.children[topicMultiLevelWildcard]; ok {
+		// the multi-level wildcard also matches the parent level (e.g., site/# matches site)
+		for key := range mw.subs {
+			fn(key)
+		}
+	}
+	if len(levels) == 0 {
+		for key := range n.subs {
+			fn(key)
+		}
+		return
+	}
+	if child, ok := n.children[levels[0]]; ok {
+		child.match(levels[1:], fn)
+	}
+	if sw, ok := n.children[topicSingleLevelWildcard]; ok {
+		sw.match(levels[1:], fn)
+	}
+}
+
+// walk invokes fn for each subscribed topic filter and its subscription keys
+func (t *topicTree) walk(fn func(filter string, subs map[string]struct{})) {
+	t.root.walk(nil, fn)
+}
+
+func (n *topicNode) walk(levels []string, fn func(filter string, subs map[string]struct{})) {
+	if len(n.subs) > 0 {
+		fn(strings.Join(levels, topicLevelSeparator), n.subs)
+	}
+	for level, child := range n.children {
+		child.walk(append(levels, level), fn)
+	}
+}
@@ -0,0 +1,80 @@
+package swagsock
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, filter := range []string{"general", "site/42/sensor/7/temp", "site/42/#", "site/+/sensor/7/temp", "+", "#", "+/+/#"} {
+		assert.NoError(t, validateTopicFilter(filter), "filter %s", filter)
+	}
+	for _, filter := range []string{"site/#/temp", "site/4+/temp", "site/42#", "#/"} {
+		assert.Error(t, validateTopicFilter(filter), "filter %s", filter)
+	}
+	assert.NoError(t, validateTopicName("site/42/sensor/7/temp"))
+	assert.Error(t, validateTopicName("site/+/sensor/7/temp"))
+	assert.Error(t, validateTopicName("site/#"))
+}
+
+func TestMatchTopicFilter(t *testing.T) {
+	for _, filter := range []string{"site/42/sensor/7/temp", "site/42/#", "site/+/sensor/7/temp", "#", "site/+/+/+/+"} {
+		assert.True(t, matchTopicFilter(filter, "site/42/sensor/7/temp"), "filter %s", filter)
+	}
+	for _, filter := range []string{"site/42/sensor/7", "site/43/#", "site/+/sensor/8/temp", "+", "site/+/+/+/+/+"} {
+		assert.False(t, matchTopicFilter(filter, "site/42/sensor/7/temp"), "filter %s", filter)
+	}
+	assert.True(t, matchTopicFilter("site/42/#", "site/42"))
+}
+
+func TestTopicTreeMatch(t *testing.T) {
+	tree := newTopicTree()
+	tree.add("site/42/sensor/7/temp", "k1")
+	tree.add("site/42/#", "k2")
+	tree.add("site/+/sensor/7/temp", "k3")
+	tree.add("#", "k4")
+	tree.add("site/+", "k5")
+	tree.add("general", "k6")
+
+	matched := func(topic string) []string {
+		keys := make([]string, 0)
+		tree.match(topic, func(key string) {
+			keys = append(keys, key)
+		})
+		sort.Strings(keys)
+		return keys
+	}
+	assert.Equal(t, []string{"k1", "k2", "k3", "k4"}, matched("site/42/sensor/7/temp"))
+	assert.Equal(t, []string{"k3", "k4"}, matched("site/43/sensor/7/temp"))
+	assert.Equal(t, []string{"k2", "k4", "k5"}, matched("site/42"))
+	assert.Equal(t, []string{"k4", "k5"}, matched("site/43"))
+	assert.Equal(t, []string{"k4", "k6"}, matched("general"))
+	assert.Equal(t, []string{"k4"}, matched("private"))
+
+	filters := make([]string, 0)
+	tree.walk(func(filter string, _ map[string]struct{}) {
+		filters = append(filters, filter)
+	})
+	sort.Strings(filters)
+	assert.Equal(t, []string{"#", "general", "site/+", "site/+/sensor/7/temp", "site/42/#", "site/42/sensor/7/temp"}, filters)
+}
+
+func TestTopicTreeRemove(t *testing.T) {
+	tree := newTopicTree()
+	assert.True(t, tree.add("site/42/sensor/7/temp", "k1"))
+	assert.True(t, tree.add("site/42/#", "k2"))
+	assert.False(t, tree.add("site/42/#", "k3"))
+
+	assert.False(t, tree.remove("site/42/#", "k2"))
+	assert.True(t, tree.remove("site/42/sensor/7/temp", "k1"))
+	// removing an unknown filter or key is ignored
+	assert.False(t, tree.remove("site/43/#", "k3"))
+	assert.False(t, tree.remove("site/42/#", "k4"))
+	assert.Equal(t, 1, len(tree.root.children))
+	assert.Equal(t, 1, len(tree.root.children["site"].children["42"].children))
+
+	assert.True(t, tree.remove("site/42/#", "k3"))
+	assert.True(t, tree.root.isEmpty())
+}
@@ -0,0 +1,95 @@
+package swagsock
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+var (
+	errInvalidTrackingID = errors.New("invalid_tracking_id")
+	errExpiredTrackingID = errors.New("expired_tracking_id")
+)
+
+// defaultTrackingIDGenerator returns a random uuid
+func defaultTrackingIDGenerator() string {
+	return uuid.NewV4().String()
+}
+
+// NewHMACTrackingIDIssuer returns a TrackingIDIssuer which issues the tracking IDs consisting of the ID returned by
+// the generator, its expiry time, and their HMAC-SHA256 signature using the secret. The issued tracking IDs are
+// accepted until they expire after ttl. If generate is nil, random uuids are used
+func NewHMACTrackingIDIssuer(secret []byte, ttl time.Duration, generate func() string) TrackingIDIssuer {
+	if generate == nil {
+		generate = defaultTrackingIDGenerator
+	}
+	return &hmacTrackingIDIssuer{secret: secret, ttl: ttl, generate: generate, now: time.Now}
+}
+
+type hmacTrackingIDIssuer struct {
+	secret   []byte
+	ttl      time.Duration
+	generate func() string
+	now      func() time.Time
+}
+
+func (ti *hmacTrackingIDIssuer) Issue() string {
+	payload := ti.generate() + "." + strconv.FormatInt(ti.now().Add(ti.ttl).Unix(), 10)
+	return payload + "." + ti.sign(payload)
+}
+
+func (ti *hmacTrackingIDIssuer) Verify(trackingID string) error {
+	p := strings.LastIndex(trackingID, ".")
+	if p < 0 {
+		return errInvalidTrackingID
+	}
+	payload := trackingID[:p]
+	if !hmac.Equal([]byte(trackingID[p+1:]), []byte(ti.sign(payload))) {
+		return errInvalidTrackingID
+	}
+	expiry, err := strconv.ParseInt(payload[strings.LastIndex(payload, ".")+1:], 10, 64)
+	if err != nil {
+		return errInvalidTrackingID
+	}
+	if ti.now().Unix() > expiry {
+		return errExpiredTrackingID
+	}
+	return nil
+}
+
+// sign returns the HMAC-SHA256 signature of the payload
+func (ti *hmacTrackingIDIssuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte(payload)) //nolint:errcheck
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTrackingID returns a tracking ID for the client connecting without one
+func (ph *protocolHandler) newTrackingID() string {
+	if ph.issuer != nil {
+		return ph.issuer.Issue()
+	}
+	return ph.generate()
+}
+
+// verifyTrackingID checks the tracking ID presented by the client with the issuer. The suffixes of the session scopes
+// given to a duplicate tracking ID are not part of the issued tracking ID. They are numeric unlike the signature
+// ending the issued tracking ID, whose generated part may contain '~' as well
+func (ph *protocolHandler) verifyTrackingID(trackingID string) error {
+	if ph.issuer == nil {
+		return nil
+	}
+	for p := strings.LastIndex(trackingID, "~"); p >= 0; p = strings.LastIndex(trackingID, "~") {
+		if _, err := strconv.ParseUint(trackingID[p+1:], 10, 64); err != nil {
+			break
+		}
+		trackingID = trackingID[:p]
+	}
+	return ph.issuer.Verify(trackingID)
+}
@@ -0,0 +1,100 @@
+package swagsock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACTrackingIDIssuer(t *testing.T) {
+	issuer := NewHMACTrackingIDIssuer([]byte("secret"), time.Minute, func() string {
+		return "naranja"
+	}).(*hmacTrackingIDIssuer)
+	trackingID := issuer.Issue()
+	assert.True(t, strings.HasPrefix(trackingID, "naranja."))
+	assert.NotContains(t, trackingID, "#")
+	assert.NoError(t, issuer.Verify(trackingID))
+
+	// the forged tracking IDs are rejected
+	assert.Equal(t, errInvalidTrackingID, issuer.Verify("naranja"))
+	assert.Equal(t, errInvalidTrackingID, issuer.Verify("manzana"+trackingID[len("naranja"):]))
+	other := NewHMACTrackingIDIssuer([]byte("other"), time.Minute, nil)
+	assert.Equal(t, errInvalidTrackingID, issuer.Verify(other.Issue()))
+
+	// the expired tracking IDs are rejected
+	issuer.now = func() time.Time {
+		return time.Now().Add(2 * time.Minute)
+	}
+	assert.Equal(t, errExpiredTrackingID, issuer.Verify(trackingID))
+}
+
+func TestVerifyTrackingIDScoped(t *testing.T) {
+	conf := NewConfig()
+	conf.TrackingIDIssuer = NewHMACTrackingIDIssuer([]byte("secret"), time.Minute, func() string {
+		return "naranja~manzana"
+	})
+	ph := CreateProtocolHandler(conf).(*protocolHandler)
+	defer ph.Destroy()
+	trackingID := conf.TrackingIDIssuer.Issue()
+	assert.NoError(t, ph.verifyTrackingID(trackingID))
+
+	// the scope suffixes are removed but not the '~' of the generated ID
+	assert.NoError(t, ph.verifyTrackingID(trackingID+"~3"))
+	assert.NoError(t, ph.verifyTrackingID(trackingID+"~3~5"))
+	assert.Equal(t, errInvalidTrackingID, ph.verifyTrackingID("naranja~"+trackingID[len("naranja~manzana"):]))
+	assert.Equal(t, errInvalidTrackingID, ph.verifyTrackingID(trackingID+"~limon"))
+}
+
+func TestServeTrackingIDIssuer(t *testing.T) {
+	conf := NewConfig()
+	conf.TrackingIDIssuer = NewHMACTrackingIDIssuer([]byte("secret"), time.Minute, nil)
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(&testEchoHandler{mediator: conf.ResponseMediator}, w, r)
+	}))
+	defer ts.Close()
+
+	// the client connecting without a tracking ID gets a signed one
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+	assert.NoError(t, err)
+	defer ws.Close() //nolint:staticcheck
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"version":"2.0"}`)))
+	var hresp HandshakeResponse
+	assert.NoError(t, ws.ReadJSON(&hresp))
+	assert.NoError(t, conf.TrackingIDIssuer.Verify(hresp.TrackingID))
+
+	// the client reconnecting with the signed tracking ID is accepted, but not with a forged one
+	ws2, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"?x-tracking-id="+hresp.TrackingID, nil)
+	assert.NoError(t, err)
+	defer ws2.Close() //nolint:staticcheck
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"?x-tracking-id="+testTrackingID, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestServeTrackingIDGenerator(t *testing.T) {
+	conf := NewConfig()
+	conf.TrackingIDGenerator = func() string {
+		return "naranja"
+	}
+	ph := CreateProtocolHandler(conf)
+	defer ph.Destroy()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Serve(&testEchoHandler{mediator: conf.ResponseMediator}, w, r)
+	}))
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+	assert.NoError(t, err)
+	defer ws.Close() //nolint:staticcheck
+	assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(`{"version":"2.0"}`)))
+	var hresp HandshakeResponse
+	assert.NoError(t, ws.ReadJSON(&hresp))
+	assert.Equal(t, "naranja", hresp.TrackingID)
+}